@@ -0,0 +1,191 @@
+// Copyright 2025 Canonical Ltd.
+// See LICENSE file for licensing details.
+
+// Package amqputil provides helpers for sharing AMQP connections.
+package amqputil
+
+import (
+	"errors"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+)
+
+// ErrClosed is returned by Channel once the ManagedConnection was closed.
+var ErrClosed = errors.New("managed connection closed")
+
+// ManagedConnection keeps a single AMQP connection open, dialling it
+// lazily and reconnecting in the background when the broker drops it.
+type ManagedConnection struct {
+	name string
+	dial func(config amqp.Config) (*amqp.Connection, error)
+	pool *ChannelPool
+
+	mu     sync.Mutex
+	conn   *amqp.Connection
+	closed bool
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewManagedConnection returns a ManagedConnection that uses dial to open
+// the underlying connection and keeps up to poolSize idle channels. The
+// config passed to dial advertises name as the connection_name client
+// property, which the broker shows in its management UI and API.
+func NewManagedConnection(name string, dial func(config amqp.Config) (*amqp.Connection, error), poolSize int) *ManagedConnection {
+	return &ManagedConnection{
+		name: name,
+		dial: dial,
+		pool: NewChannelPool(poolSize),
+		done: make(chan struct{}),
+	}
+}
+
+// Channel returns a channel on the shared connection, reusing an idle one
+// from the pool when possible and connecting first if there is no open
+// connection. Callers hand the channel back with Release, or Close it if
+// they changed its state (confirm mode, QoS, consumers).
+func (mc *ManagedConnection) Channel() (*amqp.Channel, error) {
+	conn, err := mc.connection()
+	if err != nil {
+		return nil, err
+	}
+	return mc.pool.Get(conn.Channel)
+}
+
+// Name returns the connection_name the connection advertises.
+func (mc *ManagedConnection) Name() string {
+	return mc.name
+}
+
+// IsClosed reports whether there is no open connection, either because it
+// was not dialled yet or because it was lost and not re-established.
+func (mc *ManagedConnection) IsClosed() bool {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.conn == nil || mc.conn.IsClosed()
+}
+
+// Release returns a channel obtained from Channel to the pool.
+func (mc *ManagedConnection) Release(ch *amqp.Channel) {
+	mc.pool.Put(ch)
+}
+
+// Close stops the reconnect goroutine and closes the underlying connection.
+func (mc *ManagedConnection) Close() error {
+	mc.mu.Lock()
+	if mc.closed {
+		mc.mu.Unlock()
+		return nil
+	}
+	mc.closed = true
+	close(mc.done)
+	conn := mc.conn
+	mc.conn = nil
+	mc.mu.Unlock()
+
+	mc.pool.Drain()
+	var err error
+	if conn != nil && !conn.IsClosed() {
+		err = conn.Close()
+	}
+	mc.wg.Wait()
+	return err
+}
+
+func (mc *ManagedConnection) connection() (*amqp.Connection, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if mc.closed {
+		return nil, ErrClosed
+	}
+	if mc.conn != nil && !mc.conn.IsClosed() {
+		return mc.conn, nil
+	}
+	conn, err := mc.dial(mc.config())
+	if err != nil {
+		return nil, err
+	}
+	mc.setConnection(conn)
+	return conn, nil
+}
+
+// config returns the dial configuration, matching amqp.Dial apart from the
+// connection name.
+func (mc *ManagedConnection) config() amqp.Config {
+	properties := amqp.NewConnectionProperties()
+	properties.SetClientConnectionName(mc.name)
+	return amqp.Config{
+		Locale:     "en_US",
+		Properties: properties,
+	}
+}
+
+// setConnection stores conn and starts watching it. mc.mu must be held.
+func (mc *ManagedConnection) setConnection(conn *amqp.Connection) {
+	// Channels of the previous connection are dead.
+	mc.pool.Drain()
+	mc.conn = conn
+	notify := conn.NotifyClose(make(chan *amqp.Error, 1))
+	mc.wg.Add(1)
+	go mc.watch(notify)
+}
+
+// watch waits for the connection to close and reconnects unless the close
+// was requested through Close.
+func (mc *ManagedConnection) watch(notify <-chan *amqp.Error) {
+	defer mc.wg.Done()
+
+	select {
+	case <-mc.done:
+		return
+	case amqpErr, ok := <-notify:
+		if !ok || amqpErr == nil {
+			// Graceful close, the next Channel call will dial again.
+			return
+		}
+		log.Printf("RabbitMQ connection lost: %v", amqpErr)
+	}
+
+	delay := minReconnectDelay
+	for {
+		// Add up to 50% jitter so replicas do not reconnect in lockstep.
+		wait := delay + rand.N(delay/2)
+		select {
+		case <-mc.done:
+			return
+		case <-time.After(wait):
+		}
+
+		mc.mu.Lock()
+		if mc.closed {
+			mc.mu.Unlock()
+			return
+		}
+		if mc.conn != nil && !mc.conn.IsClosed() {
+			// A concurrent Channel call already reconnected.
+			mc.mu.Unlock()
+			return
+		}
+		conn, err := mc.dial(mc.config())
+		if err == nil {
+			mc.setConnection(conn)
+			mc.mu.Unlock()
+			log.Println("RabbitMQ connection re-established")
+			return
+		}
+		mc.mu.Unlock()
+
+		log.Printf("RabbitMQ reconnect failed: %v", err)
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
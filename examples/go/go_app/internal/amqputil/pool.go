@@ -0,0 +1,86 @@
+// Copyright 2025 Canonical Ltd.
+// See LICENSE file for licensing details.
+
+package amqputil
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// DefaultChannelPoolSize is the number of idle channels kept by default.
+const DefaultChannelPoolSize = 10
+
+// ChannelPool keeps up to a fixed number of idle channels for reuse.
+//
+// A bounded free list is used rather than a sync.Pool: sync.Pool may drop
+// items at any GC without closing them, which would leak channels that stay
+// open on the broker until the connection goes away.
+type ChannelPool = channelPool[*amqp.Channel]
+
+// pooledChannel is the part of *amqp.Channel the pool uses.
+type pooledChannel interface {
+	comparable
+	IsClosed() bool
+	Flow(active bool) error
+	Close() error
+}
+
+type channelPool[C pooledChannel] struct {
+	idle chan C
+}
+
+// NewChannelPool returns a pool holding at most size idle channels.
+func NewChannelPool(size int) *ChannelPool {
+	return newChannelPool[*amqp.Channel](size)
+}
+
+func newChannelPool[C pooledChannel](size int) *channelPool[C] {
+	return &channelPool[C]{idle: make(chan C, size)}
+}
+
+// Get returns an idle channel that is still usable, or a new one from open
+// when there is none. Idle channels are checked with Flow(true) and
+// discarded if the broker rejects it.
+func (p *channelPool[C]) Get(open func() (C, error)) (C, error) {
+	for {
+		select {
+		case ch := <-p.idle:
+			if ch.IsClosed() {
+				continue
+			}
+			if err := ch.Flow(true); err != nil {
+				ch.Close()
+				continue
+			}
+			return ch, nil
+		default:
+			return open()
+		}
+	}
+}
+
+// Put returns ch to the pool, closing it instead when it is already closed
+// or the pool is full.
+func (p *channelPool[C]) Put(ch C) {
+	var none C
+	if ch == none || ch.IsClosed() {
+		return
+	}
+	select {
+	case p.idle <- ch:
+	default:
+		ch.Close()
+	}
+}
+
+// Drain closes all idle channels.
+func (p *channelPool[C]) Drain() {
+	for {
+		select {
+		case ch := <-p.idle:
+			ch.Close()
+		default:
+			return
+		}
+	}
+}
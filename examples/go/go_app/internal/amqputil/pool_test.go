@@ -0,0 +1,107 @@
+// Copyright 2025 Canonical Ltd.
+// See LICENSE file for licensing details.
+
+package amqputil
+
+import (
+	"errors"
+	"testing"
+)
+
+// fakeChannel is a pooledChannel that records how it was used.
+type fakeChannel struct {
+	closed  bool
+	flowErr error
+	closes  int
+}
+
+func (c *fakeChannel) IsClosed() bool { return c.closed }
+
+func (c *fakeChannel) Flow(bool) error { return c.flowErr }
+
+func (c *fakeChannel) Close() error {
+	c.closes++
+	c.closed = true
+	return nil
+}
+
+func TestChannelPoolGet(t *testing.T) {
+	tests := []struct {
+		name string
+		// idle is in the pool before Get.
+		idle       *fakeChannel
+		wantReused bool
+		wantClosed bool
+		wantOpened int
+	}{
+		{name: "empty pool opens a channel", wantOpened: 1},
+		{name: "usable idle channel is reused", idle: &fakeChannel{}, wantReused: true},
+		{name: "closed idle channel is skipped", idle: &fakeChannel{closed: true}, wantClosed: true, wantOpened: 1},
+		{name: "rejected idle channel is closed", idle: &fakeChannel{flowErr: errors.New("channel closed")}, wantClosed: true, wantOpened: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newChannelPool[*fakeChannel](2)
+			if tt.idle != nil {
+				p.idle <- tt.idle
+			}
+			opened := 0
+			fresh := &fakeChannel{}
+			ch, err := p.Get(func() (*fakeChannel, error) {
+				opened++
+				return fresh, nil
+			})
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if opened != tt.wantOpened {
+				t.Errorf("Get() opened %d channels, want %d", opened, tt.wantOpened)
+			}
+			if tt.wantReused && ch != tt.idle {
+				t.Errorf("Get() did not reuse the idle channel")
+			}
+			if !tt.wantReused && ch != fresh {
+				t.Errorf("Get() did not return the opened channel")
+			}
+			if tt.idle != nil && tt.idle.closed != tt.wantClosed {
+				t.Errorf("idle channel closed = %v, want %v", tt.idle.closed, tt.wantClosed)
+			}
+		})
+	}
+}
+
+func TestChannelPoolGetOpenError(t *testing.T) {
+	p := newChannelPool[*fakeChannel](1)
+	openErr := errors.New("connection closed")
+	if _, err := p.Get(func() (*fakeChannel, error) { return nil, openErr }); !errors.Is(err, openErr) {
+		t.Errorf("Get() error = %v, want %v", err, openErr)
+	}
+}
+
+func TestChannelPoolPut(t *testing.T) {
+	p := newChannelPool[*fakeChannel](1)
+
+	first, second := &fakeChannel{}, &fakeChannel{}
+	p.Put(first)
+	p.Put(second)
+	if first.closes != 0 {
+		t.Errorf("pooled channel closed %d times, want 0", first.closes)
+	}
+	if second.closes != 1 {
+		t.Errorf("channel put in a full pool closed %d times, want 1", second.closes)
+	}
+
+	closed := &fakeChannel{closed: true}
+	p.Drain()
+	p.Put(closed)
+	p.Put(nil)
+	if closed.closes != 0 {
+		t.Errorf("closed channel closed again %d times, want 0", closed.closes)
+	}
+	if len(p.idle) != 0 {
+		t.Errorf("pool holds %d channels, want 0", len(p.idle))
+	}
+	if first.closes != 1 {
+		t.Errorf("drained channel closed %d times, want 1", first.closes)
+	}
+}
@@ -0,0 +1,720 @@
+// Copyright 2025 Canonical Ltd.
+// See LICENSE file for licensing details.
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	openfga "github.com/openfga/go-sdk"
+	fgaclient "github.com/openfga/go-sdk/client"
+	"github.com/openfga/go-sdk/credentials"
+)
+
+// ErrNoAuthorizationModel is returned when the store has no authorization
+// model to resolve "latest" to.
+var ErrNoAuthorizationModel = errors.New("store has no authorization model")
+
+// ErrInvalidAuthorizationModel is returned when an authorization model to
+// write cannot be decoded.
+var ErrInvalidAuthorizationModel = errors.New("invalid authorization model")
+
+// fgaRetryMinWait is the base delay of the SDK's exponential back-off.
+const fgaRetryMinWait = 100 * time.Millisecond
+
+// OpenFGAClient builds an OpenFGA SDK client from the FGA_* settings. The SDK
+// retries 429 and 5xx responses with exponential back-off, honouring
+// Retry-After, and returns other errors immediately. When FGAOAuth2TokenURL
+// is set, requests use the token from OpenFGATokenExchange instead of
+// FGA_TOKEN itself.
+func (s *Service) OpenFGAClient() (*fgaclient.OpenFgaClient, error) {
+	config := &fgaclient.ClientConfiguration{
+		ApiUrl:  s.FGAAPIURL,
+		StoreId: s.FGAStoreID,
+	}
+	if s.FGAOAuth2TokenURL != "" {
+		config.HTTPClient = &http.Client{
+			Transport: fgaTokenTransport{service: s, base: http.DefaultTransport},
+		}
+	} else {
+		config.Credentials = &credentials.Credentials{
+			Method: credentials.CredentialsMethodApiToken,
+			Config: &credentials.Config{
+				ApiToken: s.FGAToken,
+			},
+		}
+	}
+	if s.FGARetryMaxAttempts > 0 {
+		config.RetryParams = &openfga.RetryParams{
+			MaxRetry:    s.FGARetryMaxAttempts - 1,
+			MinWaitInMs: int(fgaRetryMinWait.Milliseconds()),
+		}
+	}
+	return fgaclient.NewSdkClient(config)
+}
+
+// OpenFGAReindexTuples drops the in-process state that could outlive an
+// authorization model change. The SDK has no Check cache to invalidate and
+// OpenFGAClient builds a new client for every call, so this forgets the
+// exchanged token and closes pooled connections, making the next request
+// start afresh.
+func (s *Service) OpenFGAReindexTuples(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.fgaTokenMu.Lock()
+	s.fgaToken = ""
+	s.fgaTokenExpiry = time.Time{}
+	s.fgaTokenMu.Unlock()
+
+	if transport, ok := http.DefaultTransport.(*http.Transport); ok {
+		transport.CloseIdleConnections()
+	}
+	return nil
+}
+
+// AssertionResult is the outcome of evaluating a single model assertion.
+type AssertionResult struct {
+	User     string `json:"user"`
+	Relation string `json:"relation"`
+	Object   string `json:"object"`
+	Expected bool   `json:"expected"`
+	Actual   bool   `json:"actual"`
+	Passed   bool   `json:"passed"`
+}
+
+// AssertionReport summarises the evaluation of all assertions of a model.
+type AssertionReport struct {
+	Passed  int               `json:"passed"`
+	Failed  int               `json:"failed"`
+	Results []AssertionResult `json:"results"`
+}
+
+// OpenFGARunAssertions reads the assertions stored for the given model and
+// evaluates each of them with a Check against the same model.
+func (s *Service) OpenFGARunAssertions(ctx context.Context, modelID string) (*AssertionReport, error) {
+	fgaClient, err := s.OpenFGAClient()
+	if err != nil {
+		return nil, err
+	}
+
+	assertions, err := fgaClient.ReadAssertions(ctx).Options(fgaclient.ClientReadAssertionsOptions{
+		AuthorizationModelId: &modelID,
+	}).Execute()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read assertions: %w", err)
+	}
+
+	report := &AssertionReport{Results: []AssertionResult{}}
+	if assertions.Assertions == nil {
+		return report, nil
+	}
+	for _, assertion := range *assertions.Assertions {
+		request := fgaclient.ClientCheckRequest{
+			User:     assertion.TupleKey.User,
+			Relation: assertion.TupleKey.Relation,
+			Object:   assertion.TupleKey.Object,
+			Context:  assertion.Context,
+		}
+		if assertion.ContextualTuples != nil {
+			request.ContextualTuples = *assertion.ContextualTuples
+		}
+
+		check, err := fgaClient.Check(ctx).Body(request).Options(fgaclient.ClientCheckOptions{
+			AuthorizationModelId: &modelID,
+		}).Execute()
+		if err != nil {
+			return nil, fmt.Errorf("failed to check assertion %s#%s@%s: %w",
+				request.Object, request.Relation, request.User, err)
+		}
+
+		result := AssertionResult{
+			User:     request.User,
+			Relation: request.Relation,
+			Object:   request.Object,
+			Expected: assertion.Expectation,
+			Actual:   check.GetAllowed(),
+		}
+		result.Passed = result.Expected == result.Actual
+		if result.Passed {
+			report.Passed++
+		} else {
+			report.Failed++
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report, nil
+}
+
+// OpenFGACreateStore creates a new store and returns its ID.
+func (s *Service) OpenFGACreateStore(ctx context.Context, name string) (string, error) {
+	fgaClient, err := s.OpenFGAClient()
+	if err != nil {
+		return "", err
+	}
+
+	store, err := fgaClient.CreateStore(ctx).Body(fgaclient.ClientCreateStoreRequest{Name: name}).Execute()
+	if err != nil {
+		return "", fmt.Errorf("failed to create store %s: %w", name, err)
+	}
+	return store.Id, nil
+}
+
+// StoreInfo identifies an OpenFGA store.
+type StoreInfo struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// OpenFGAListStores returns every store, following the continuation token
+// until all pages were read.
+func (s *Service) OpenFGAListStores(ctx context.Context) ([]StoreInfo, error) {
+	fgaClient, err := s.OpenFGAClient()
+	if err != nil {
+		return nil, err
+	}
+
+	options := fgaclient.ClientListStoresOptions{}
+	stores := []StoreInfo{}
+	for {
+		response, err := fgaClient.ListStores(ctx).Options(options).Execute()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list stores: %w", err)
+		}
+		for _, store := range response.Stores {
+			stores = append(stores, StoreInfo{ID: store.Id, Name: store.Name})
+		}
+		if response.ContinuationToken == "" {
+			return stores, nil
+		}
+		options.ContinuationToken = &response.ContinuationToken
+	}
+}
+
+// OpenFGADeleteStore deletes the store with the given ID.
+func (s *Service) OpenFGADeleteStore(ctx context.Context, storeID string) error {
+	fgaClient, err := s.OpenFGAClient()
+	if err != nil {
+		return err
+	}
+
+	_, err = fgaClient.DeleteStore(ctx).Options(fgaclient.ClientDeleteStoreOptions{StoreId: &storeID}).Execute()
+	if err != nil {
+		return fmt.Errorf("failed to delete store %s: %w", storeID, err)
+	}
+	return nil
+}
+
+// OpenFGATupleChanges polls ReadChanges from continuationToken every
+// pollInterval and calls emit for each change until ctx is done or emit
+// fails. The last change of every page is emitted with the continuation
+// token that resumes after it; the other changes get an empty token.
+func (s *Service) OpenFGATupleChanges(ctx context.Context, continuationToken string, pollInterval time.Duration, emit func(change openfga.TupleChange, token string) error) error {
+	fgaClient, err := s.OpenFGAClient()
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		options := fgaclient.ClientReadChangesOptions{}
+		if continuationToken != "" {
+			options.ContinuationToken = &continuationToken
+		}
+		response, err := fgaClient.ReadChanges(ctx).Body(fgaclient.ClientReadChangesRequest{}).Options(options).Execute()
+		if err != nil {
+			return fmt.Errorf("failed to read changes: %w", err)
+		}
+		if response.ContinuationToken != nil && *response.ContinuationToken != "" {
+			continuationToken = *response.ContinuationToken
+		}
+
+		for i, change := range response.Changes {
+			token := ""
+			if i == len(response.Changes)-1 {
+				token = continuationToken
+			}
+			if err := emit(change, token); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// recentAuthorizationModelID returns the ID of the model at position n of
+// the store history, 0 being the most recent; the API lists models newest
+// first.
+func recentAuthorizationModelID(ctx context.Context, fgaClient *fgaclient.OpenFgaClient, n int) (string, error) {
+	pageSize := int32(n + 1)
+	models, err := fgaClient.ReadAuthorizationModels(ctx).Options(fgaclient.ClientReadAuthorizationModelsOptions{
+		PageSize: &pageSize,
+	}).Execute()
+	if err != nil {
+		return "", fmt.Errorf("failed to list authorization models: %w", err)
+	}
+	if len(models.AuthorizationModels) <= n {
+		return "", ErrNoAuthorizationModel
+	}
+	return models.AuthorizationModels[n].Id, nil
+}
+
+// latestAuthorizationModelID returns the ID of the most recent model.
+func latestAuthorizationModelID(ctx context.Context, fgaClient *fgaclient.OpenFgaClient) (string, error) {
+	return recentAuthorizationModelID(ctx, fgaClient, 0)
+}
+
+// OpenFGAListAuthorizationModelsByType returns the IDs of the authorization
+// models, most recent first, that define typeName. The API cannot filter
+// models, so all of them are read and filtered here.
+func (s *Service) OpenFGAListAuthorizationModelsByType(ctx context.Context, typeName string) ([]string, error) {
+	fgaClient, err := s.OpenFGAClient()
+	if err != nil {
+		return nil, err
+	}
+
+	options := fgaclient.ClientReadAuthorizationModelsOptions{}
+	modelIDs := []string{}
+	for {
+		response, err := fgaClient.ReadAuthorizationModels(ctx).Options(options).Execute()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list authorization models: %w", err)
+		}
+		for _, model := range response.AuthorizationModels {
+			for _, typeDefinition := range model.TypeDefinitions {
+				if typeDefinition.Type == typeName {
+					modelIDs = append(modelIDs, model.Id)
+					break
+				}
+			}
+		}
+		if response.ContinuationToken == nil || *response.ContinuationToken == "" {
+			return modelIDs, nil
+		}
+		options.ContinuationToken = response.ContinuationToken
+	}
+}
+
+// OpenFGAReadAuthorizationModel returns the authorization model with the
+// given ID, or the most recent one when modelID is "latest".
+func (s *Service) OpenFGAReadAuthorizationModel(ctx context.Context, modelID string) (*openfga.AuthorizationModel, error) {
+	fgaClient, err := s.OpenFGAClient()
+	if err != nil {
+		return nil, err
+	}
+
+	if modelID == "latest" {
+		if modelID, err = latestAuthorizationModelID(ctx, fgaClient); err != nil {
+			return nil, err
+		}
+	}
+	response, err := fgaClient.ReadAuthorizationModel(ctx).Options(fgaclient.ClientReadAuthorizationModelOptions{
+		AuthorizationModelId: &modelID,
+	}).Execute()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read authorization model %s: %w", modelID, err)
+	}
+	if response.AuthorizationModel == nil {
+		return nil, ErrNoAuthorizationModel
+	}
+	return response.AuthorizationModel, nil
+}
+
+// fgaMaxWriteSize is the largest number of tuples the Write API accepts in
+// one call.
+const fgaMaxWriteSize = 100
+
+// OpenFGAImportTuples writes tuples in chunks of chunkSize, one chunk after
+// the other, and calls progress with the number of tuples written so far
+// after each chunk. Transient errors are retried by the SDK client; any
+// other error stops the import with the earlier chunks already written.
+func (s *Service) OpenFGAImportTuples(ctx context.Context, tuples []openfga.TupleKey, chunkSize int, progress func(written int) error) error {
+	if chunkSize <= 0 || chunkSize > fgaMaxWriteSize {
+		return fmt.Errorf("chunk size must be between 1 and %d", fgaMaxWriteSize)
+	}
+	fgaClient, err := s.OpenFGAClient()
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(tuples); start += chunkSize {
+		end := min(start+chunkSize, len(tuples))
+		_, err := fgaClient.WriteTuples(ctx).Body(tuples[start:end]).Execute()
+		if err != nil {
+			return fmt.Errorf("failed to write tuples %d to %d: %w", start, end-1, err)
+		}
+		if err := progress(end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FGAReadPageSize is the largest page size the Read API accepts.
+const FGAReadPageSize = 100
+
+// OpenFGATupleCount returns the number of tuples in the store by paging
+// through all of them, so it costs one Read call per 100 tuples.
+func (s *Service) OpenFGATupleCount(ctx context.Context) (int, error) {
+	fgaClient, err := s.OpenFGAClient()
+	if err != nil {
+		return 0, err
+	}
+
+	pageSize := int32(FGAReadPageSize)
+	options := fgaclient.ClientReadOptions{PageSize: &pageSize}
+	count := 0
+	for {
+		response, err := fgaClient.Read(ctx).Body(fgaclient.ClientReadRequest{}).Options(options).Execute()
+		if err != nil {
+			return 0, fmt.Errorf("failed to read tuples: %w", err)
+		}
+		count += len(response.Tuples)
+		if response.ContinuationToken == "" {
+			return count, nil
+		}
+		options.ContinuationToken = &response.ContinuationToken
+	}
+}
+
+// TupleFilter selects the tuples returned by OpenFGATupleSearch. Empty
+// fields match everything; the Read API requires Object or ObjectType when
+// User is set.
+type TupleFilter struct {
+	User     string
+	Relation string
+	Object   string
+	// ObjectType matches all the objects of the type when Object is
+	// empty.
+	ObjectType string
+}
+
+// TuplePage is a page of tuples. NextCursor is empty on the last page.
+type TuplePage struct {
+	Tuples     []openfga.Tuple `json:"tuples"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// OpenFGATupleSearch reads one page of at most pageSize tuples matching
+// filter, starting at cursor, the NextCursor of the previous page, or at
+// the first tuple when cursor is empty.
+func (s *Service) OpenFGATupleSearch(ctx context.Context, filter TupleFilter, pageSize int, cursor string) (*TuplePage, error) {
+	if pageSize <= 0 || pageSize > FGAReadPageSize {
+		return nil, fmt.Errorf("page size must be between 1 and %d", FGAReadPageSize)
+	}
+	fgaClient, err := s.OpenFGAClient()
+	if err != nil {
+		return nil, err
+	}
+
+	request := fgaclient.ClientReadRequest{}
+	if filter.User != "" {
+		request.User = &filter.User
+	}
+	if filter.Relation != "" {
+		request.Relation = &filter.Relation
+	}
+	if filter.Object != "" {
+		request.Object = &filter.Object
+	} else if filter.ObjectType != "" {
+		object := filter.ObjectType + ":"
+		request.Object = &object
+	}
+	size := int32(pageSize)
+	options := fgaclient.ClientReadOptions{PageSize: &size}
+	if cursor != "" {
+		options.ContinuationToken = &cursor
+	}
+	response, err := fgaClient.Read(ctx).Body(request).Options(options).Execute()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tuples: %w", err)
+	}
+	return &TuplePage{Tuples: response.Tuples, NextCursor: response.ContinuationToken}, nil
+}
+
+// OpenFGAListUsers returns the users of type userType that have relation on
+// object, given as type:id, in the form user:alice, group:eng#member or
+// user:* for a public wildcard, sorted. ListUsers is not paginated by the
+// API, so all of them are returned.
+func (s *Service) OpenFGAListUsers(ctx context.Context, object, relation, userType string) ([]string, error) {
+	objectType, objectID, found := strings.Cut(object, ":")
+	if !found || objectType == "" || objectID == "" {
+		return nil, fmt.Errorf("%w: object %q is not of the form type:id", ErrInvalidName, object)
+	}
+	fgaClient, err := s.OpenFGAClient()
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := fgaClient.ListUsers(ctx).Body(fgaclient.ClientListUsersRequest{
+		Object:      openfga.FgaObject{Type: objectType, Id: objectID},
+		Relation:    relation,
+		UserFilters: []openfga.UserTypeFilter{{Type: userType}},
+	}).Execute()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	users := make([]string, 0, len(response.Users))
+	for _, user := range response.Users {
+		switch {
+		case user.Object != nil:
+			users = append(users, user.Object.Type+":"+user.Object.Id)
+		case user.Userset != nil:
+			users = append(users, user.Userset.Type+":"+user.Userset.Id+"#"+user.Userset.Relation)
+		case user.Wildcard != nil:
+			users = append(users, user.Wildcard.Type+":*")
+		}
+	}
+	slices.Sort(users)
+	return users, nil
+}
+
+// ModelDiff lists the type definitions that differ between two models.
+type ModelDiff struct {
+	FromID       string   `json:"from_id"`
+	ToID         string   `json:"to_id"`
+	AddedTypes   []string `json:"added_types"`
+	RemovedTypes []string `json:"removed_types"`
+	ChangedTypes []string `json:"changed_types"`
+}
+
+// OpenFGAModelDiff compares the type definitions of the models fromID and
+// toID. A fromID of "latest" stands for the model before the most recent
+// one, so that from=latest&to=latest shows what the last write changed; a
+// toID of "latest" is the most recent model.
+func (s *Service) OpenFGAModelDiff(ctx context.Context, fromID, toID string) (*ModelDiff, error) {
+	fgaClient, err := s.OpenFGAClient()
+	if err != nil {
+		return nil, err
+	}
+	if fromID == "latest" {
+		if fromID, err = recentAuthorizationModelID(ctx, fgaClient, 1); err != nil {
+			return nil, err
+		}
+	}
+
+	from, err := s.OpenFGAReadAuthorizationModel(ctx, fromID)
+	if err != nil {
+		return nil, err
+	}
+	to, err := s.OpenFGAReadAuthorizationModel(ctx, toID)
+	if err != nil {
+		return nil, err
+	}
+
+	fromTypes, err := typeDefinitionsByName(from)
+	if err != nil {
+		return nil, err
+	}
+	toTypes, err := typeDefinitionsByName(to)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &ModelDiff{
+		FromID:       from.Id,
+		ToID:         to.Id,
+		AddedTypes:   []string{},
+		RemovedTypes: []string{},
+		ChangedTypes: []string{},
+	}
+	for name, definition := range toTypes {
+		previous, ok := fromTypes[name]
+		switch {
+		case !ok:
+			diff.AddedTypes = append(diff.AddedTypes, name)
+		case previous != definition:
+			diff.ChangedTypes = append(diff.ChangedTypes, name)
+		}
+	}
+	for name := range fromTypes {
+		if _, ok := toTypes[name]; !ok {
+			diff.RemovedTypes = append(diff.RemovedTypes, name)
+		}
+	}
+	slices.Sort(diff.AddedTypes)
+	slices.Sort(diff.RemovedTypes)
+	slices.Sort(diff.ChangedTypes)
+	return diff, nil
+}
+
+// typeDefinitionsByName returns the JSON encoding of each type definition
+// of model keyed by type name. encoding/json sorts map keys, so equal
+// definitions encode identically.
+func typeDefinitionsByName(model *openfga.AuthorizationModel) (map[string]string, error) {
+	definitions := make(map[string]string, len(model.TypeDefinitions))
+	for _, definition := range model.TypeDefinitions {
+		data, err := json.Marshal(definition)
+		if err != nil {
+			return nil, err
+		}
+		definitions[definition.Type] = string(data)
+	}
+	return definitions, nil
+}
+
+// CheckRequest is a single relationship check.
+type CheckRequest struct {
+	User     string `json:"user"`
+	Relation string `json:"relation"`
+	Object   string `json:"object"`
+}
+
+// EvalResult is the throughput and latency distribution of a batch of
+// checks. Failed checks are counted in Errors and left out of the
+// latencies.
+type EvalResult struct {
+	Checks            int           `json:"checks"`
+	Errors            int           `json:"errors"`
+	Allowed           int           `json:"allowed"`
+	Duration          time.Duration `json:"duration_ns"`
+	RequestsPerSecond float64       `json:"requests_per_second"`
+	P50               time.Duration `json:"p50_ns"`
+	P95               time.Duration `json:"p95_ns"`
+	P99               time.Duration `json:"p99_ns"`
+}
+
+// OpenFGARunAuthorizationEvaluation runs every check of checks once, with
+// concurrency checks in flight at a time, against the latest model and
+// reports the achieved throughput and latency percentiles.
+func (s *Service) OpenFGARunAuthorizationEvaluation(ctx context.Context, checks []CheckRequest, concurrency int) (*EvalResult, error) {
+	if len(checks) == 0 || concurrency <= 0 {
+		return nil, fmt.Errorf("at least one check and a positive concurrency are required")
+	}
+	fgaClient, err := s.OpenFGAClient()
+	if err != nil {
+		return nil, err
+	}
+	// Resolve the model once so the checks do not each look it up.
+	modelID, err := latestAuthorizationModelID(ctx, fgaClient)
+	if err != nil {
+		return nil, err
+	}
+
+	type outcome struct {
+		latency time.Duration
+		allowed bool
+		err     error
+	}
+	outcomes := make([]outcome, len(checks))
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for range min(concurrency, len(checks)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				checkStart := time.Now()
+				response, err := fgaClient.Check(ctx).Body(fgaclient.ClientCheckRequest{
+					User:     checks[i].User,
+					Relation: checks[i].Relation,
+					Object:   checks[i].Object,
+				}).Options(fgaclient.ClientCheckOptions{AuthorizationModelId: &modelID}).Execute()
+				outcomes[i] = outcome{latency: time.Since(checkStart), err: err}
+				if err == nil {
+					outcomes[i].allowed = response.GetAllowed()
+				}
+			}
+		}()
+	}
+	for i := range checks {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+
+	result := &EvalResult{Checks: len(checks), Duration: time.Since(start)}
+	latencies := make([]time.Duration, 0, len(outcomes))
+	for _, outcome := range outcomes {
+		if outcome.err != nil {
+			result.Errors++
+			continue
+		}
+		if outcome.allowed {
+			result.Allowed++
+		}
+		latencies = append(latencies, outcome.latency)
+	}
+	result.RequestsPerSecond = float64(len(checks)) / result.Duration.Seconds()
+	slices.Sort(latencies)
+	result.P50 = percentile(latencies, 50)
+	result.P95 = percentile(latencies, 95)
+	result.P99 = percentile(latencies, 99)
+	return result, ctx.Err()
+}
+
+// percentile returns the nearest-rank p-th percentile of the sorted
+// latencies, or zero when there are none.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+// OpenFGAMigrateModel writes schema, an authorization model in the JSON
+// format of the WriteAuthorizationModel API, and returns the new model ID.
+// OpenFGA only makes the model the latest once it was written whole, so a
+// rejected model leaves the previous one active. When PostgreSQL is
+// configured, the ID is then recorded as model_id in the fga_config table
+// for deployments pinning APP_FGA_MODEL_ID; if that fails the new ID is
+// returned with the error.
+func (s *Service) OpenFGAMigrateModel(ctx context.Context, schema string) (string, error) {
+	var model fgaclient.ClientWriteAuthorizationModelRequest
+	if err := json.Unmarshal([]byte(schema), &model); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidAuthorizationModel, err)
+	}
+	fgaClient, err := s.OpenFGAClient()
+	if err != nil {
+		return "", err
+	}
+
+	response, err := fgaClient.WriteAuthorizationModel(ctx).Body(model).Execute()
+	if err != nil {
+		return "", fmt.Errorf("failed to write authorization model: %w", err)
+	}
+	modelID := response.GetAuthorizationModelId()
+
+	if s.PostgresqlURL == "" {
+		return modelID, nil
+	}
+	if err := s.recordFGAModelID(ctx, modelID); err != nil {
+		return modelID, fmt.Errorf("model %s written but not recorded: %w", modelID, err)
+	}
+	return modelID, nil
+}
+
+// recordFGAModelID stores modelID as model_id in the fga_config table,
+// creating the table if needed.
+func (s *Service) recordFGAModelID(ctx context.Context, modelID string) error {
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS fga_config (key text PRIMARY KEY, value text NOT NULL)"); err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO fga_config (key, value) VALUES ('model_id', $1)
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value`, modelID)
+	return err
+}
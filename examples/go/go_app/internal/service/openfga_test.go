@@ -0,0 +1,42 @@
+// Copyright 2025 Canonical Ltd.
+// See LICENSE file for licensing details.
+
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	// 1ms to 10ms.
+	ten := make([]time.Duration, 10)
+	for i := range ten {
+		ten[i] = time.Duration(i+1) * time.Millisecond
+	}
+
+	tests := []struct {
+		name   string
+		sorted []time.Duration
+		p      int
+		want   time.Duration
+	}{
+		{"empty", nil, 50, 0},
+		{"single p50", []time.Duration{7}, 50, 7},
+		{"single p99", []time.Duration{7}, 99, 7},
+		{"p0 is the minimum", ten, 0, time.Millisecond},
+		{"p10", ten, 10, time.Millisecond},
+		{"p11 rounds up", ten, 11, 2 * time.Millisecond},
+		{"p50", ten, 50, 5 * time.Millisecond},
+		{"p95", ten, 95, 10 * time.Millisecond},
+		{"p99", ten, 99, 10 * time.Millisecond},
+		{"p100 is the maximum", ten, 100, 10 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentile(tt.sorted, tt.p); got != tt.want {
+				t.Errorf("percentile(%v, %d) = %v, want %v", tt.sorted, tt.p, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2025 Canonical Ltd.
+// See LICENSE file for licensing details.
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// tokenExchangeGrantType is the RFC 8693 token exchange grant.
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	// accessTokenType identifies an OAuth 2.0 access token in RFC 8693.
+	accessTokenType = "urn:ietf:params:oauth:token-type:access_token"
+	// fgaTokenRefreshMargin is how long before its expiry the exchanged
+	// token is replaced.
+	fgaTokenRefreshMargin = 30 * time.Second
+)
+
+// OpenFGATokenExchange returns a bearer token for OpenFGA obtained with an
+// RFC 8693 token exchange of FGA_TOKEN at FGAOAuth2TokenURL. The token is
+// cached and exchanged again 30 seconds before it expires. The exchange
+// runs without the cache lock held, so concurrent callers may each refresh
+// the token; the last one to finish is kept.
+func (s *Service) OpenFGATokenExchange(ctx context.Context) (string, error) {
+	s.fgaTokenMu.Lock()
+	if s.fgaToken != "" && time.Now().Before(s.fgaTokenExpiry.Add(-fgaTokenRefreshMargin)) {
+		token := s.fgaToken
+		s.fgaTokenMu.Unlock()
+		return token, nil
+	}
+	s.fgaTokenMu.Unlock()
+
+	token, expiry, err := s.exchangeFGAToken(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	s.fgaTokenMu.Lock()
+	defer s.fgaTokenMu.Unlock()
+	s.fgaToken = token
+	s.fgaTokenExpiry = expiry
+	return token, nil
+}
+
+// exchangeFGAToken exchanges FGA_TOKEN at FGAOAuth2TokenURL and returns the
+// new token with its expiry. Without expires_in the token expires at once,
+// so it is exchanged again on the next request.
+func (s *Service) exchangeFGAToken(ctx context.Context) (string, time.Time, error) {
+	form := url.Values{
+		"grant_type":         {tokenExchangeGrantType},
+		"subject_token":      {s.FGAToken},
+		"subject_token_type": {accessTokenType},
+	}
+	if s.FGAAudience != "" {
+		form.Set("audience", s.FGAAudience)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.FGAOAuth2TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.SetBasicAuth(url.QueryEscape(s.FGAClientID), url.QueryEscape(s.FGAClientSecret))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.httpClient().Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("token exchange request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", time.Time{}, fmt.Errorf("token exchange failed with status %d: %s", resp.StatusCode, data)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to decode token exchange response: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("token exchange response has no access_token")
+	}
+	return token.AccessToken, time.Now().Add(time.Duration(token.ExpiresIn) * time.Second), nil
+}
+
+// fgaTokenTransport authorizes every OpenFGA request with the token from
+// OpenFGATokenExchange, so long-lived clients pick up refreshed tokens.
+type fgaTokenTransport struct {
+	service *Service
+	base    http.RoundTripper
+}
+
+func (t fgaTokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.service.OpenFGATokenExchange(req.Context())
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,1681 @@
+// Copyright 2025 Canonical Ltd.
+// See LICENSE file for licensing details.
+
+package service
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+var identifierPattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// ValidateIdentifier checks that name is a plain SQL identifier that is
+// safe to interpolate into a statement.
+func ValidateIdentifier(name string) error {
+	if !identifierPattern.MatchString(name) {
+		return fmt.Errorf("%w: identifier %q", ErrInvalidName, name)
+	}
+	return nil
+}
+
+// postgresqlDB returns the connection pool shared by the PostgreSQL
+// methods, opening it on first use. Its sessions run with
+// PostgresqlStatementTimeout as statement_timeout when it is set.
+func (s *Service) postgresqlDB() (*sql.DB, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.db != nil {
+		return s.db, nil
+	}
+	db, err := s.openPostgresqlDB(s.PostgresqlStatementTimeout)
+	if err != nil {
+		return nil, err
+	}
+	s.db = db
+	return s.db, nil
+}
+
+// postgresqlMaintenanceDB returns the connection pool used for DDL, VACUUM
+// and ANALYZE, opening it on first use. Its sessions
+// keep the server statement_timeout: such statements legitimately run for
+// longer than PostgresqlStatementTimeout, and cancelling CREATE INDEX
+// CONCURRENTLY half way leaves an invalid index behind.
+func (s *Service) postgresqlMaintenanceDB() (*sql.DB, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maintenanceDB != nil {
+		return s.maintenanceDB, nil
+	}
+	db, err := s.openPostgresqlDB(0)
+	if err != nil {
+		return nil, err
+	}
+	s.maintenanceDB = db
+	return s.maintenanceDB, nil
+}
+
+// openPostgresqlDB opens a pool to PostgresqlURL whose sessions run with
+// statementTimeout as statement_timeout, unless it is zero.
+func (s *Service) openPostgresqlDB(statementTimeout time.Duration) (*sql.DB, error) {
+	if s.PostgresqlURL == "" {
+		return nil, fmt.Errorf("POSTGRESQL_DB_CONNECT_STRING not set")
+	}
+	config, err := pgx.ParseConfig(s.PostgresqlURL)
+	if err != nil {
+		return nil, err
+	}
+	if statementTimeout > 0 {
+		config.RuntimeParams["statement_timeout"] = strconv.FormatInt(statementTimeout.Milliseconds(), 10)
+	}
+	return stdlib.OpenDB(*config), nil
+}
+
+// PoolStats is a snapshot of the shared PostgreSQL connection pool.
+type PoolStats struct {
+	OpenConnections int64 `json:"open_connections"`
+	InUse           int64 `json:"in_use"`
+	Idle            int64 `json:"idle"`
+	// WaitCount and WaitDuration accumulate over the pool lifetime.
+	WaitCount    int64         `json:"wait_count"`
+	WaitDuration time.Duration `json:"wait_duration_ns"`
+}
+
+// PostgresqlConnectionPoolStats returns the statistics of the shared pool.
+// They are all zero until the pool is opened by a first query.
+func (s *Service) PostgresqlConnectionPoolStats() PoolStats {
+	s.mu.Lock()
+	db := s.db
+	s.mu.Unlock()
+	if db == nil {
+		return PoolStats{}
+	}
+
+	stats := db.Stats()
+	return PoolStats{
+		OpenConnections: int64(stats.OpenConnections),
+		InUse:           int64(stats.InUse),
+		Idle:            int64(stats.Idle),
+		WaitCount:       stats.WaitCount,
+		WaitDuration:    stats.WaitDuration,
+	}
+}
+
+// PostgresqlWaitForReady pings PostgreSQL every interval until it accepts
+// connections or ctx is done.
+func (s *Service) PostgresqlWaitForReady(ctx context.Context, interval time.Duration) error {
+	if s.PostgresqlURL == "" {
+		return fmt.Errorf("POSTGRESQL_DB_CONNECT_STRING not set")
+	}
+	// Health checks use their own connection, free of statement_timeout.
+	db, err := sql.Open("pgx", s.PostgresqlURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := db.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+		log.Printf("DEBUG: PostgreSQL not ready yet: %v", err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// PostgresqlExecuteTransaction runs statements in order inside a single
+// transaction, rolling back if any of them fails.
+func (s *Service) PostgresqlExecuteTransaction(ctx context.Context, statements []string) error {
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for i, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("statement %d (%q) failed: %w", i, statement, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// IndexInfo describes an index of a table.
+type IndexInfo struct {
+	Name       string `json:"name"`
+	Unique     bool   `json:"unique"`
+	Definition string `json:"definition"`
+}
+
+// PostgresqlListIndexes lists the indexes defined on table in the current
+// schema.
+func (s *Service) PostgresqlListIndexes(ctx context.Context, table string) ([]IndexInfo, error) {
+	if err := ValidateIdentifier(table); err != nil {
+		return nil, err
+	}
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx,
+		`SELECT indexname, indexdef LIKE 'CREATE UNIQUE INDEX%', indexdef
+		FROM pg_indexes WHERE schemaname = current_schema() AND tablename = $1 ORDER BY indexname`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	indexes := []IndexInfo{}
+	for rows.Next() {
+		var index IndexInfo
+		if err := rows.Scan(&index.Name, &index.Unique, &index.Definition); err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, rows.Err()
+}
+
+// ErrTableNotFound is returned when a table does not exist.
+var ErrTableNotFound = errors.New("table not found")
+
+// PostgresqlRowCount returns the number of rows in table. When exact is
+// false the planner estimate from pg_class is returned, which is cheap but
+// is -1 for tables that were never vacuumed or analyzed. When exact is true
+// the table is fully scanned with count(*). It returns ErrTableNotFound
+// when table does not exist.
+func (s *Service) PostgresqlRowCount(ctx context.Context, table string, exact bool) (int64, error) {
+	if err := ValidateIdentifier(table); err != nil {
+		return 0, err
+	}
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	if exact {
+		err = db.QueryRowContext(ctx, "SELECT count(*) FROM "+table).Scan(&count)
+	} else {
+		err = db.QueryRowContext(ctx, "SELECT reltuples::bigint FROM pg_class WHERE oid = $1::regclass", table).Scan(&count)
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == undefinedTableCode {
+		return 0, fmt.Errorf("%w: %s", ErrTableNotFound, table)
+	}
+	return count, err
+}
+
+// PostgresqlCreateIndexConcurrently builds an index on table(column) with
+// CREATE INDEX CONCURRENTLY so writes are not blocked while it builds.
+// CONCURRENTLY is not allowed inside a transaction, so the statement runs
+// on its own connection in autocommit mode. A failed concurrent build
+// leaves an invalid index that IF NOT EXISTS would keep, so an invalid
+// index of that name on table is dropped before the build, and after a
+// failed one if the build created it.
+func (s *Service) PostgresqlCreateIndexConcurrently(ctx context.Context, table, name, column string, unique bool) error {
+	for _, identifier := range []string{table, name, column} {
+		if err := ValidateIdentifier(identifier); err != nil {
+			return err
+		}
+	}
+	db, err := s.postgresqlMaintenanceDB()
+	if err != nil {
+		return err
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	existed, invalid, err := indexState(ctx, conn, table, name)
+	if err != nil {
+		return err
+	}
+	if invalid {
+		log.Printf("Dropping invalid index %s left by a failed build", name)
+		if _, err := conn.ExecContext(ctx, "DROP INDEX CONCURRENTLY IF EXISTS "+name); err != nil {
+			return fmt.Errorf("failed to drop invalid index %s: %w", name, err)
+		}
+		existed = false
+	}
+
+	uniqueClause := ""
+	if unique {
+		uniqueClause = "UNIQUE "
+	}
+	statement := fmt.Sprintf("CREATE %sINDEX CONCURRENTLY IF NOT EXISTS %s ON %s (%s)", uniqueClause, name, table, column)
+	if _, err := conn.ExecContext(ctx, statement); err != nil {
+		// Only drop what this build left behind: the statement also fails
+		// for a wrong table or column while a valid index has the name.
+		cleanupCtx := context.WithoutCancel(ctx)
+		if _, leftInvalid, stateErr := indexState(cleanupCtx, conn, table, name); stateErr != nil {
+			log.Printf("Failed to check index %s after a failed build: %v", name, stateErr)
+		} else if !existed && leftInvalid {
+			if _, dropErr := conn.ExecContext(cleanupCtx, "DROP INDEX CONCURRENTLY IF EXISTS "+name); dropErr != nil {
+				log.Printf("Failed to drop index %s after a failed build: %v", name, dropErr)
+			}
+		}
+		return fmt.Errorf("failed to create index %s: %w", name, err)
+	}
+	return nil
+}
+
+// indexState reports whether an index called name exists in the current
+// schema, and whether it is an invalid index on table.
+func indexState(ctx context.Context, conn *sql.Conn, table, name string) (exists, invalid bool, err error) {
+	err = conn.QueryRowContext(ctx, `
+		SELECT NOT i.indisvalid AND t.relname = $2
+		FROM pg_index i
+		JOIN pg_class c ON c.oid = i.indexrelid
+		JOIN pg_class t ON t.oid = i.indrelid
+		WHERE c.relname = $1 AND c.relnamespace = current_schema()::regnamespace`, name, table).Scan(&invalid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, fmt.Errorf("failed to check index %s: %w", name, err)
+	}
+	return true, invalid, nil
+}
+
+// ConstraintInfo describes a constraint of a table.
+type ConstraintInfo struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Columns []string `json:"columns"`
+}
+
+// PostgresqlListConstraints lists the constraints defined on table. Columns
+// are the constrained columns of the table itself; for CHECK constraints,
+// which have no key columns, the columns the check uses are reported.
+func (s *Service) PostgresqlListConstraints(ctx context.Context, table string) ([]ConstraintInfo, error) {
+	if err := ValidateIdentifier(table); err != nil {
+		return nil, err
+	}
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT tc.constraint_name, tc.constraint_type, COALESCE(
+			(SELECT array_agg(kcu.column_name::text ORDER BY kcu.ordinal_position)
+			FROM information_schema.key_column_usage kcu
+			WHERE kcu.constraint_schema = tc.constraint_schema AND kcu.constraint_name = tc.constraint_name),
+			(SELECT array_agg(ccu.column_name::text ORDER BY ccu.column_name)
+			FROM information_schema.constraint_column_usage ccu
+			WHERE ccu.constraint_schema = tc.constraint_schema AND ccu.constraint_name = tc.constraint_name),
+			'{}')
+		FROM information_schema.table_constraints tc
+		WHERE tc.table_name = $1 AND tc.table_schema = current_schema()
+		ORDER BY tc.constraint_name`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	typeMap := pgtype.NewMap()
+	constraints := []ConstraintInfo{}
+	for rows.Next() {
+		var constraint ConstraintInfo
+		if err := rows.Scan(&constraint.Name, &constraint.Type, typeMap.SQLScanner(&constraint.Columns)); err != nil {
+			return nil, err
+		}
+		constraints = append(constraints, constraint)
+	}
+	return constraints, rows.Err()
+}
+
+// PartitionInfo describes a partition of a partitioned table.
+type PartitionInfo struct {
+	Name string `json:"name"`
+	// Constraint is the partition bound, such as
+	// "FOR VALUES FROM ('2025-01-01') TO ('2025-02-01')".
+	Constraint string `json:"constraint"`
+	// RowCount is the live row estimate of the statistics collector.
+	RowCount int64 `json:"row_count"`
+}
+
+// PostgresqlPartitionInfo lists the partitions of the table parent in the
+// current schema.
+func (s *Service) PostgresqlPartitionInfo(ctx context.Context, parent string) ([]PartitionInfo, error) {
+	if err := ValidateIdentifier(parent); err != nil {
+		return nil, err
+	}
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT c.relname, coalesce(pg_get_expr(c.relpartbound, c.oid), ''), coalesce(st.n_live_tup, 0)
+		FROM pg_inherits i
+		JOIN pg_class p ON p.oid = i.inhparent
+		JOIN pg_class c ON c.oid = i.inhrelid
+		LEFT JOIN pg_stat_user_tables st ON st.relid = c.oid
+		WHERE p.relname = $1 AND p.relnamespace = current_schema()::regnamespace
+		ORDER BY c.relname`, parent)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	partitions := []PartitionInfo{}
+	for rows.Next() {
+		var partition PartitionInfo
+		if err := rows.Scan(&partition.Name, &partition.Constraint, &partition.RowCount); err != nil {
+			return nil, err
+		}
+		partitions = append(partitions, partition)
+	}
+	return partitions, rows.Err()
+}
+
+// PostgresqlKillIdleConnections terminates the backends of the current
+// database that have been idle in transaction for longer than olderThan and
+// returns how many were terminated. The calling backend is never included.
+func (s *Service) PostgresqlKillIdleConnections(ctx context.Context, olderThan time.Duration) (int, error) {
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return 0, err
+	}
+
+	var terminated int
+	err = db.QueryRowContext(ctx, `
+		SELECT count(*) FILTER (WHERE terminated) FROM (
+			SELECT pg_terminate_backend(pid) AS terminated
+			FROM pg_stat_activity
+			WHERE state = 'idle in transaction'
+				AND now() - state_change > $1 * interval '1 second'
+				AND datname = current_database()
+				AND pid <> pg_backend_pid()
+		) AS t`, olderThan.Seconds()).Scan(&terminated)
+	if err != nil {
+		return 0, fmt.Errorf("failed to terminate idle connections: %w", err)
+	}
+	return terminated, nil
+}
+
+// PostgresqlVacuumFull rewrites table with VACUUM FULL. The table is locked
+// exclusively until the rewrite finishes.
+func (s *Service) PostgresqlVacuumFull(ctx context.Context, table string) error {
+	if err := ValidateIdentifier(table); err != nil {
+		return err
+	}
+	db, err := s.postgresqlMaintenanceDB()
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.ExecContext(ctx, "VACUUM FULL "+table); err != nil {
+		return fmt.Errorf("failed to vacuum %s: %w", table, err)
+	}
+	return nil
+}
+
+// PostgresqlRunAnalyze refreshes the planner statistics of table with
+// ANALYZE, or of every table of the database when table is empty or "*".
+// The statement runs on its own, outside any transaction block.
+func (s *Service) PostgresqlRunAnalyze(ctx context.Context, table string) error {
+	statement := "ANALYZE"
+	if table != "" && table != "*" {
+		if err := ValidateIdentifier(table); err != nil {
+			return err
+		}
+		statement += " " + table
+	}
+	db, err := s.postgresqlMaintenanceDB()
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.ExecContext(ctx, statement); err != nil {
+		return fmt.Errorf("failed to analyze: %w", err)
+	}
+	return nil
+}
+
+// maxActiveQueryLength is the number of characters of each query reported
+// by PostgresqlActiveQueries.
+const maxActiveQueryLength = 200
+
+// ActiveQuery is a query currently running on the server.
+type ActiveQuery struct {
+	PID           int           `json:"pid"`
+	Duration      time.Duration `json:"duration_ns"`
+	Query         string        `json:"query"`
+	WaitEventType string        `json:"wait_event_type"`
+}
+
+// PostgresqlActiveQueries lists the queries that have been running for
+// longer than longerThanMs milliseconds, longest first, leaving out the
+// query that does the listing.
+func (s *Service) PostgresqlActiveQueries(ctx context.Context, longerThanMs int) ([]ActiveQuery, error) {
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT pid, extract(epoch FROM now() - query_start), left(query, $2), COALESCE(wait_event_type, '')
+		FROM pg_stat_activity
+		WHERE state = 'active'
+			AND now() - query_start > $1 * interval '1 ms'
+			AND pid <> pg_backend_pid()
+		ORDER BY query_start`, longerThanMs, maxActiveQueryLength)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	queries := []ActiveQuery{}
+	for rows.Next() {
+		var query ActiveQuery
+		var seconds float64
+		if err := rows.Scan(&query.PID, &seconds, &query.Query, &query.WaitEventType); err != nil {
+			return nil, err
+		}
+		query.Duration = time.Duration(seconds * float64(time.Second))
+		queries = append(queries, query)
+	}
+	return queries, rows.Err()
+}
+
+// ConnectionCount is the number of server connections in a state opened by
+// an application. Background processes have an empty State.
+type ConnectionCount struct {
+	State           string `json:"state"`
+	ApplicationName string `json:"application_name"`
+	Count           int    `json:"count"`
+}
+
+// PostgresqlConnectionCount counts the server connections by state and
+// application, most common first.
+func (s *Service) PostgresqlConnectionCount(ctx context.Context) ([]ConnectionCount, error) {
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT coalesce(state, ''), application_name, count(*)
+		FROM pg_stat_activity
+		GROUP BY state, application_name
+		ORDER BY count(*) DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []ConnectionCount{}
+	for rows.Next() {
+		var count ConnectionCount
+		if err := rows.Scan(&count.State, &count.ApplicationName, &count.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
+
+// ErrPreparedStatementNotFound is returned when no prepared statement has the
+// requested name.
+var ErrPreparedStatementNotFound = errors.New("prepared statement not found")
+
+// PreparedStatement is a statement prepared on a database session.
+type PreparedStatement struct {
+	Name           string   `json:"name"`
+	Statement      string   `json:"statement"`
+	ParameterTypes []string `json:"parameter_types"`
+}
+
+// PostgresqlPreparedStatements lists the statements prepared on one of the
+// pooled sessions. pg_prepared_statements is local to its session, so other
+// connections of the pool may hold different statements.
+func (s *Service) PostgresqlPreparedStatements(ctx context.Context) ([]PreparedStatement, error) {
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT name, statement, parameter_types::text[]
+		FROM pg_prepared_statements
+		ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	typeMap := pgtype.NewMap()
+	statements := []PreparedStatement{}
+	for rows.Next() {
+		var statement PreparedStatement
+		if err := rows.Scan(&statement.Name, &statement.Statement, typeMap.SQLScanner(&statement.ParameterTypes)); err != nil {
+			return nil, err
+		}
+		statements = append(statements, statement)
+	}
+	return statements, rows.Err()
+}
+
+// PostgresqlQueryPlan returns the generic execution plan of the prepared
+// statement name. The lookup and the EXPLAIN run on the same session, since
+// prepared statements are local to it; ErrPreparedStatementNotFound is
+// returned when that session has no such statement.
+func (s *Service) PostgresqlQueryPlan(ctx context.Context, name string) (string, error) {
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return "", err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	var parameters int
+	err = conn.QueryRowContext(ctx, "SELECT cardinality(parameter_types) FROM pg_prepared_statements WHERE name = $1", name).Scan(&parameters)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("%w: %s", ErrPreparedStatementNotFound, name)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	// The generic plan does not depend on the arguments, so NULL stands in
+	// for each of them.
+	if _, err := tx.ExecContext(ctx, "SET LOCAL plan_cache_mode = force_generic_plan"); err != nil {
+		return "", err
+	}
+	explain := "EXPLAIN EXECUTE " + pgx.Identifier{name}.Sanitize()
+	if parameters > 0 {
+		explain += "(" + strings.TrimSuffix(strings.Repeat("NULL, ", parameters), ", ") + ")"
+	}
+	rows, err := tx.QueryContext(ctx, explain)
+	if err != nil {
+		return "", fmt.Errorf("failed to explain %s: %w", name, err)
+	}
+	defer rows.Close()
+
+	var plan []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return "", err
+		}
+		plan = append(plan, line)
+	}
+	return strings.Join(plan, "\n"), rows.Err()
+}
+
+// Extension is a PostgreSQL extension installed in the database.
+type Extension struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// PostgresqlListExtensions lists the extensions installed in the database.
+func (s *Service) PostgresqlListExtensions(ctx context.Context) ([]Extension, error) {
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT name, installed_version
+		FROM pg_available_extensions
+		WHERE installed_version IS NOT NULL
+		ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	extensions := []Extension{}
+	for rows.Next() {
+		var extension Extension
+		if err := rows.Scan(&extension.Name, &extension.Version); err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, extension)
+	}
+	return extensions, rows.Err()
+}
+
+// allowedExtensions are the extensions PostgresqlEnableExtension and
+// PostgresqlDisableExtension accept.
+var allowedExtensions = map[string]bool{
+	"uuid-ossp": true, "pgcrypto": true, "pg_stat_statements": true, "hstore": true, "postgis": true,
+}
+
+// PostgresqlEnableExtension installs one of the allowed extensions if it is
+// not installed yet.
+func (s *Service) PostgresqlEnableExtension(ctx context.Context, name string) error {
+	if !allowedExtensions[name] {
+		return fmt.Errorf("%w: extension %q", ErrInvalidName, name)
+	}
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.ExecContext(ctx, "CREATE EXTENSION IF NOT EXISTS "+pgx.Identifier{name}.Sanitize()); err != nil {
+		return fmt.Errorf("failed to create extension %s: %w", name, err)
+	}
+	return nil
+}
+
+// PostgresqlDisableExtension drops one of the allowed extensions if it is
+// installed.
+func (s *Service) PostgresqlDisableExtension(ctx context.Context, name string) error {
+	if !allowedExtensions[name] {
+		return fmt.Errorf("%w: extension %q", ErrInvalidName, name)
+	}
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.ExecContext(ctx, "DROP EXTENSION IF EXISTS "+pgx.Identifier{name}.Sanitize()); err != nil {
+		return fmt.Errorf("failed to drop extension %s: %w", name, err)
+	}
+	return nil
+}
+
+// ErrTimescaleNotInstalled is returned when the timescaledb extension is
+// not installed in the database.
+var ErrTimescaleNotInstalled = errors.New("timescaledb extension is not installed")
+
+// PostgresqlCreateHypertable turns table into a TimescaleDB hypertable
+// partitioned on timeColumn in chunks of chunkInterval. The timescaledb
+// extension must be installed; ErrTimescaleNotInstalled is returned
+// otherwise.
+func (s *Service) PostgresqlCreateHypertable(ctx context.Context, table, timeColumn string, chunkInterval time.Duration) error {
+	for _, identifier := range []string{table, timeColumn} {
+		if err := ValidateIdentifier(identifier); err != nil {
+			return err
+		}
+	}
+	if chunkInterval <= 0 {
+		return fmt.Errorf("chunk interval must be positive, got %s", chunkInterval)
+	}
+	db, err := s.postgresqlMaintenanceDB()
+	if err != nil {
+		return err
+	}
+
+	extensions, err := s.PostgresqlListExtensions(ctx)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(extensions, func(extension Extension) bool { return extension.Name == "timescaledb" }) {
+		return ErrTimescaleNotInstalled
+	}
+
+	_, err = db.ExecContext(ctx, "SELECT create_hypertable($1::regclass, $2::name, chunk_time_interval => make_interval(secs => $3))",
+		table, timeColumn, chunkInterval.Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to create hypertable %s: %w", table, err)
+	}
+	return nil
+}
+
+// ErrPgStatStatementsNotInstalled is returned when the pg_stat_statements
+// extension is not installed in the database.
+var ErrPgStatStatementsNotInstalled = errors.New("pg_stat_statements extension is not installed")
+
+const (
+	// undefinedTableCode is the SQLSTATE of undefined_table.
+	undefinedTableCode = "42P01"
+	// duplicateObjectCode is the SQLSTATE of duplicate_object.
+	duplicateObjectCode = "42710"
+)
+
+// SlowQuery is the execution statistics of a normalized statement.
+type SlowQuery struct {
+	Query           string  `json:"query"`
+	Calls           int64   `json:"calls"`
+	MeanExecTimeMs  float64 `json:"mean_exec_time_ms"`
+	TotalExecTimeMs float64 `json:"total_exec_time_ms"`
+}
+
+// PostgresqlSlowQueryLog returns the limit statements with the highest mean
+// execution time recorded by pg_stat_statements.
+func (s *Service) PostgresqlSlowQueryLog(ctx context.Context, limit int) ([]SlowQuery, error) {
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT query, calls, mean_exec_time, total_exec_time
+		FROM pg_stat_statements
+		ORDER BY mean_exec_time DESC
+		LIMIT $1`, limit)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == undefinedTableCode {
+		return nil, ErrPgStatStatementsNotInstalled
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	queries := []SlowQuery{}
+	for rows.Next() {
+		var query SlowQuery
+		if err := rows.Scan(&query.Query, &query.Calls, &query.MeanExecTimeMs, &query.TotalExecTimeMs); err != nil {
+			return nil, err
+		}
+		queries = append(queries, query)
+	}
+	return queries, rows.Err()
+}
+
+// PostgresqlCreateRole creates the role name, allowed to log in when login
+// is true. An existing role is left untouched.
+func (s *Service) PostgresqlCreateRole(ctx context.Context, name string, login bool) error {
+	if err := ValidateIdentifier(name); err != nil {
+		return err
+	}
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return err
+	}
+
+	loginClause := "NOLOGIN"
+	if login {
+		loginClause = "LOGIN"
+	}
+	// PostgreSQL has no CREATE ROLE IF NOT EXISTS.
+	_, err = db.ExecContext(ctx, fmt.Sprintf("CREATE ROLE %s %s", name, loginClause))
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == duplicateObjectCode {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create role %s: %w", name, err)
+	}
+	return nil
+}
+
+// tablePrivileges are the privileges PostgresqlGrantRole accepts.
+var tablePrivileges = map[string]bool{
+	"SELECT": true, "INSERT": true, "UPDATE": true, "DELETE": true,
+	"TRUNCATE": true, "REFERENCES": true, "TRIGGER": true, "ALL": true,
+}
+
+// PostgresqlGrantRole grants privilege on table to role.
+func (s *Service) PostgresqlGrantRole(ctx context.Context, role, table, privilege string) error {
+	for _, identifier := range []string{role, table} {
+		if err := ValidateIdentifier(identifier); err != nil {
+			return err
+		}
+	}
+	privilege = strings.ToUpper(privilege)
+	if !tablePrivileges[privilege] {
+		return fmt.Errorf("%w: privilege %q", ErrInvalidName, privilege)
+	}
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("GRANT %s ON %s TO %s", privilege, table, role)); err != nil {
+		return fmt.Errorf("failed to grant %s on %s to %s: %w", privilege, table, role, err)
+	}
+	return nil
+}
+
+// LockInfo pairs a backend waiting for a lock with a backend blocking it.
+type LockInfo struct {
+	BlockedPID    int    `json:"blocked_pid"`
+	BlockedQuery  string `json:"blocked_query"`
+	BlockingPID   int    `json:"blocking_pid"`
+	BlockingQuery string `json:"blocking_query"`
+	LockType      string `json:"lock_type"`
+}
+
+// PostgresqlCheckLocks lists the backends waiting for a lock together with
+// every backend blocking them.
+func (s *Service) PostgresqlCheckLocks(ctx context.Context) ([]LockInfo, error) {
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return nil, err
+	}
+
+	// pg_blocking_pids resolves the lock conflict rules, including waits
+	// queued behind other waiters, which a pg_locks self-join gets wrong.
+	rows, err := db.QueryContext(ctx, `
+		SELECT blocked.pid, blocked.query, blocking.pid, blocking.query, l.locktype
+		FROM pg_locks l
+		JOIN pg_stat_activity blocked USING (pid)
+		CROSS JOIN LATERAL unnest(pg_blocking_pids(l.pid)) AS b(pid)
+		JOIN pg_stat_activity blocking ON blocking.pid = b.pid
+		WHERE NOT l.granted
+		ORDER BY blocked.pid, blocking.pid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	locks := []LockInfo{}
+	for rows.Next() {
+		var lock LockInfo
+		if err := rows.Scan(&lock.BlockedPID, &lock.BlockedQuery, &lock.BlockingPID, &lock.BlockingQuery, &lock.LockType); err != nil {
+			return nil, err
+		}
+		locks = append(locks, lock)
+	}
+	return locks, rows.Err()
+}
+
+// PostgresqlCancelQuery cancels the query running on backend pid, keeping
+// its connection open. It returns false when pid is not a server process.
+func (s *Service) PostgresqlCancelQuery(ctx context.Context, pid int) (bool, error) {
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return false, err
+	}
+
+	var cancelled bool
+	if err := db.QueryRowContext(ctx, "SELECT pg_cancel_backend($1)", pid).Scan(&cancelled); err != nil {
+		return false, fmt.Errorf("failed to cancel query of %d: %w", pid, err)
+	}
+	return cancelled, nil
+}
+
+// PostgresqlWithSchema runs fn in a transaction whose search_path is
+// schema, then public, and commits when fn succeeds. The search_path is set
+// with SET LOCAL so it does not leak to other users of the pooled
+// connection.
+func (s *Service) PostgresqlWithSchema(ctx context.Context, schema string, fn func(*sql.Tx) error) error {
+	if err := ValidateIdentifier(schema); err != nil {
+		return err
+	}
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("SET LOCAL search_path = %s, public", schema)); err != nil {
+		return fmt.Errorf("failed to set search_path to %s: %w", schema, err)
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ErrAdvisoryLockHeld is returned when an advisory lock is held in a
+// conflicting mode by another session.
+var ErrAdvisoryLockHeld = errors.New("advisory lock is held by another session")
+
+// WithSharedAdvisoryLock runs fn while holding the session advisory lock
+// lockID in shared mode, so readers run concurrently with each other but not
+// with a session holding the lock exclusively. It does not wait: when lockID
+// is held exclusively, ErrAdvisoryLockHeld is returned without running fn.
+func (s *Service) WithSharedAdvisoryLock(ctx context.Context, lockID int64, fn func() error) error {
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return err
+	}
+	// Session locks are released by the session that took them.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock_shared($1)", lockID).Scan(&acquired); err != nil {
+		return fmt.Errorf("failed to acquire shared advisory lock %d: %w", lockID, err)
+	}
+	if !acquired {
+		return fmt.Errorf("%w: %d", ErrAdvisoryLockHeld, lockID)
+	}
+	defer func() {
+		_, err := conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock_shared($1)", lockID)
+		if err != nil {
+			log.Printf("Failed to release shared advisory lock %d: %v", lockID, err)
+			// Discard the session rather than pool it with the lock held.
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+	}()
+
+	return fn()
+}
+
+// ErrQueryTooExpensive is returned when the planner's cost estimate of a
+// query exceeds the allowed budget.
+var ErrQueryTooExpensive = errors.New("query cost exceeds the budget")
+
+// ErrQueryNotPlannable is returned when the cost budget cannot be checked
+// because the query is not a statement EXPLAIN plans.
+var ErrQueryNotPlannable = errors.New("only statements EXPLAIN can plan are allowed under a cost budget")
+
+var (
+	// plannableQueryPattern matches the statements EXPLAIN accepts.
+	plannableQueryPattern = regexp.MustCompile(`(?is)^\(*\s*(SELECT|INSERT|UPDATE|DELETE|MERGE|VALUES|WITH|TABLE|DECLARE|EXECUTE)\b` +
+		`|^CREATE\s+((GLOBAL|LOCAL)\s+)?((TEMP|TEMPORARY|UNLOGGED)\s+)?TABLE\b.*\bAS\b` +
+		`|^CREATE\s+MATERIALIZED\s+VIEW\b.*\bAS\b`)
+	// explainPrefixPattern matches EXPLAIN and its options, up to the
+	// explained statement.
+	explainPrefixPattern = regexp.MustCompile(`(?is)^EXPLAIN\b(\s*\([^)]*\)|\s+(ANALYZE|ANALYSE|VERBOSE)\b)*`)
+)
+
+// stripLeadingComments removes the whitespace and the line and, possibly
+// nested, block comments at the start of query.
+func stripLeadingComments(query string) string {
+	for {
+		query = strings.TrimLeft(query, " \t\r\n\f")
+		switch {
+		case strings.HasPrefix(query, "--"):
+			end := strings.IndexByte(query, '\n')
+			if end < 0 {
+				return ""
+			}
+			query = query[end+1:]
+		case strings.HasPrefix(query, "/*"):
+			depth, i := 1, 2
+			for depth > 0 && i < len(query) {
+				switch {
+				case strings.HasPrefix(query[i:], "/*"):
+					depth++
+					i += 2
+				case strings.HasPrefix(query[i:], "*/"):
+					depth--
+					i += 2
+				default:
+					i++
+				}
+			}
+			if depth > 0 {
+				return ""
+			}
+			query = query[i:]
+		default:
+			return query
+		}
+	}
+}
+
+// plannedStatement returns the statement of query whose cost is budgeted:
+// query without leading comments, or the statement an EXPLAIN runs. It
+// returns false when that statement is not one EXPLAIN plans.
+func plannedStatement(query string) (string, bool) {
+	statement := stripLeadingComments(query)
+	for {
+		prefix := explainPrefixPattern.FindString(statement)
+		if prefix == "" {
+			break
+		}
+		statement = stripLeadingComments(statement[len(prefix):])
+	}
+	return statement, plannableQueryPattern.MatchString(statement)
+}
+
+// PostgresqlExplainBudget plans query with args, without running it, and
+// returns ErrQueryTooExpensive when the estimated total cost of the plan's
+// root node exceeds maxCost. The statement an EXPLAIN runs is the one
+// planned, and queries EXPLAIN cannot plan, such as DDL or SET, are
+// rejected with ErrQueryNotPlannable.
+func (s *Service) PostgresqlExplainBudget(ctx context.Context, query string, args []interface{}, maxCost float64) error {
+	statement, ok := plannedStatement(query)
+	if !ok {
+		return ErrQueryNotPlannable
+	}
+
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return err
+	}
+
+	var explain []byte
+	if err := db.QueryRowContext(ctx, "EXPLAIN (FORMAT JSON) "+statement, args...).Scan(&explain); err != nil {
+		return fmt.Errorf("failed to explain query: %w", err)
+	}
+	var plans []struct {
+		Plan struct {
+			TotalCost float64 `json:"Total Cost"`
+		} `json:"Plan"`
+	}
+	if err := json.Unmarshal(explain, &plans); err != nil || len(plans) == 0 {
+		return fmt.Errorf("failed to decode query plan: %v", err)
+	}
+	if cost := plans[0].Plan.TotalCost; cost > maxCost {
+		return fmt.Errorf("%w: estimated cost %.2f above %.2f", ErrQueryTooExpensive, cost, maxCost)
+	}
+	return nil
+}
+
+// PostgresqlQuery runs query with args and returns its rows as ScanRows
+// does.
+func (s *Service) PostgresqlQuery(ctx context.Context, query string, args []interface{}) ([]map[string]any, error) {
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return ScanRows(rows)
+}
+
+// ScanRows reads all rows into one map per row keyed by column name. Text
+// and bytea values are returned as strings, so the result encodes to JSON.
+func ScanRows(rows *sql.Rows) ([]map[string]any, error) {
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	result := []map[string]any{}
+	for rows.Next() {
+		values := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+
+		row := make(map[string]any, len(columns))
+		for i, column := range columns {
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			row[column] = values[i]
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// ErrQueryNotReadOnly is returned when a query given for export is not a
+// single read-only query.
+var ErrQueryNotReadOnly = errors.New("query must be a single SELECT, WITH, VALUES or TABLE query")
+
+var readOnlyQueryPattern = regexp.MustCompile(`(?is)^\s*(SELECT|WITH|VALUES|TABLE)\b`)
+
+// validateExportQuery rejects queries that could end the COPY statement
+// they are embedded in: statement separators, comments and unbalanced
+// parentheses. Parentheses in string literals are counted too, which may
+// reject some valid queries.
+func validateExportQuery(query string) error {
+	if !readOnlyQueryPattern.MatchString(query) || strings.Contains(query, ";") ||
+		strings.Contains(query, "--") || strings.Contains(query, "/*") {
+		return ErrQueryNotReadOnly
+	}
+	depth := 0
+	for _, c := range query {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+		if depth < 0 {
+			return ErrQueryNotReadOnly
+		}
+	}
+	if depth != 0 {
+		return ErrQueryNotReadOnly
+	}
+	return nil
+}
+
+// PostgresqlCopyTo streams the result of query to w as CSV with a header
+// row, using COPY TO STDOUT. The query runs in a read-only transaction on
+// the shared pool, so PostgresqlStatementTimeout bounds it.
+func (s *Service) PostgresqlCopyTo(ctx context.Context, query string, w io.Writer) error {
+	if err := validateExportQuery(query); err != nil {
+		return err
+	}
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return err
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		pgxConn := driverConn.(*stdlib.Conn).Conn()
+		tx, err := pgxConn.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback(ctx)
+
+		if _, err := pgxConn.PgConn().CopyTo(ctx, w, "COPY ("+query+") TO STDOUT WITH (FORMAT csv, HEADER)"); err != nil {
+			return fmt.Errorf("failed to export query: %w", err)
+		}
+		return tx.Commit(ctx)
+	})
+}
+
+// readErrRecorder keeps the first error other than io.EOF returned by r.
+type readErrRecorder struct {
+	r   io.Reader
+	err error
+}
+
+func (rr *readErrRecorder) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	if err != nil && err != io.EOF && rr.err == nil {
+		rr.err = err
+	}
+	return n, err
+}
+
+// PostgresqlCopyFromCSV streams the CSV data read from r into table with
+// COPY FROM STDIN and returns the number of rows inserted. The first line of
+// the data is a header and is skipped, not matched against the columns:
+// fields are assigned to columns in order, or to all the columns of the
+// table when columns is empty. An error reading r is returned wrapped, so
+// callers can tell it from a failure of the copy itself.
+func (s *Service) PostgresqlCopyFromCSV(ctx context.Context, table string, columns []string, r io.Reader) (int64, error) {
+	for _, identifier := range append([]string{table}, columns...) {
+		if err := ValidateIdentifier(identifier); err != nil {
+			return 0, err
+		}
+	}
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return 0, err
+	}
+
+	statement := "COPY " + table
+	if len(columns) > 0 {
+		statement += " (" + strings.Join(columns, ", ") + ")"
+	}
+	statement += " FROM STDIN WITH (FORMAT csv, HEADER)"
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	var rows int64
+	src := &readErrRecorder{r: r}
+	err = conn.Raw(func(driverConn any) error {
+		pgxConn := driverConn.(*stdlib.Conn).Conn()
+		tag, err := pgxConn.PgConn().CopyFrom(ctx, src, statement)
+		if err != nil && src.err != nil {
+			// The server only reports that the copy was aborted.
+			return fmt.Errorf("failed to read CSV data for %s: %w", table, src.err)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to copy into %s: %w", table, err)
+		}
+		rows = tag.RowsAffected()
+		return nil
+	})
+	return rows, err
+}
+
+// TableSize is the disk usage of a table.
+type TableSize struct {
+	Table      string `json:"table"`
+	TotalBytes int64  `json:"total_bytes"`
+	DataBytes  int64  `json:"data_bytes"`
+	IndexBytes int64  `json:"index_bytes"`
+}
+
+// PostgresqlTableSize returns the disk usage of the tables in the current
+// schema, largest first. TotalBytes also counts TOAST data, so it can
+// exceed DataBytes plus IndexBytes.
+func (s *Service) PostgresqlTableSize(ctx context.Context) ([]TableSize, error) {
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT relname, pg_total_relation_size(oid), pg_relation_size(oid), pg_indexes_size(oid)
+		FROM pg_class
+		WHERE relkind = 'r' AND relnamespace = current_schema()::regnamespace
+		ORDER BY pg_total_relation_size(oid) DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sizes := []TableSize{}
+	for rows.Next() {
+		var size TableSize
+		if err := rows.Scan(&size.Table, &size.TotalBytes, &size.DataBytes, &size.IndexBytes); err != nil {
+			return nil, err
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes, rows.Err()
+}
+
+// TableStats is the access and tuple statistics of a table since the
+// statistics were last reset.
+type TableStats struct {
+	Table        string `json:"table"`
+	SeqScan      int64  `json:"seq_scan"`
+	IdxScan      int64  `json:"idx_scan"`
+	DeadTuples   int64  `json:"n_dead_tup"`
+	LiveTuples   int64  `json:"n_live_tup"`
+	HeapBlksHit  int64  `json:"heap_blks_hit"`
+	HeapBlksRead int64  `json:"heap_blks_read"`
+}
+
+// PostgresqlExtendedStats returns the statistics of the tables in the
+// current schema. The block counters come from pg_statio_user_tables, the
+// others from pg_stat_user_tables.
+func (s *Service) PostgresqlExtendedStats(ctx context.Context) ([]TableStats, error) {
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT t.relname, t.seq_scan, coalesce(t.idx_scan, 0), t.n_dead_tup, t.n_live_tup,
+			coalesce(io.heap_blks_hit, 0), coalesce(io.heap_blks_read, 0)
+		FROM pg_stat_user_tables t
+		JOIN pg_statio_user_tables io USING (relid)
+		WHERE t.schemaname = current_schema()
+		ORDER BY t.relname`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []TableStats{}
+	for rows.Next() {
+		var table TableStats
+		if err := rows.Scan(&table.Table, &table.SeqScan, &table.IdxScan, &table.DeadTuples, &table.LiveTuples,
+			&table.HeapBlksHit, &table.HeapBlksRead); err != nil {
+			return nil, err
+		}
+		stats = append(stats, table)
+	}
+	return stats, rows.Err()
+}
+
+// SequenceStatus is how much of its range a sequence has used.
+type SequenceStatus struct {
+	Sequence    string  `json:"sequence"`
+	LastValue   int64   `json:"last_value"`
+	MaxValue    int64   `json:"max_value"`
+	PercentUsed float64 `json:"percent_used"`
+}
+
+// PostgresqlSequenceStatus reports the sequences the current user can read,
+// most used first. Descending sequences count towards their MINVALUE and
+// sequences that were never used report 0%.
+func (s *Service) PostgresqlSequenceStatus(ctx context.Context) ([]SequenceStatus, error) {
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT sequence, last_value, max_value, percent_used FROM (
+			SELECT schemaname || '.' || sequencename AS sequence,
+				COALESCE(last_value, start_value) AS last_value,
+				max_value,
+				CASE
+					WHEN last_value IS NULL THEN 0
+					WHEN increment_by > 0 THEN (last_value::numeric - min_value) / (max_value::numeric - min_value) * 100
+					ELSE (max_value::numeric - last_value) / (max_value::numeric - min_value) * 100
+				END::float8 AS percent_used
+			FROM pg_sequences
+		) AS s
+		ORDER BY percent_used DESC, sequence`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sequences := []SequenceStatus{}
+	for rows.Next() {
+		var sequence SequenceStatus
+		if err := rows.Scan(&sequence.Sequence, &sequence.LastValue, &sequence.MaxValue, &sequence.PercentUsed); err != nil {
+			return nil, err
+		}
+		sequences = append(sequences, sequence)
+	}
+	return sequences, rows.Err()
+}
+
+// changeNotifierName is the name of the trigger and trigger function
+// installed by PostgresqlInstallChangeNotifier on table.
+func changeNotifierName(table string) string {
+	return "notify_" + table + "_changes"
+}
+
+// notifyPayloadLimit is the size NOTIFY payloads must stay below.
+const notifyPayloadLimit = 8000
+
+// PostgresqlInstallChangeNotifier installs a trigger on table that sends
+// every inserted, updated or deleted row as JSON with pg_notify on channel.
+// A row whose JSON would exceed the NOTIFY payload limit, and fail the
+// write, is sent as {"op": TG_OP, "key": {...}} with the primary key
+// columns only; key is null for tables without a primary key.
+func (s *Service) PostgresqlInstallChangeNotifier(ctx context.Context, table, channel string) error {
+	for _, identifier := range []string{table, channel} {
+		if err := ValidateIdentifier(identifier); err != nil {
+			return err
+		}
+	}
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT a.attname
+		FROM pg_index i
+		JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY (i.indkey)
+		WHERE i.indrelid = $1::regclass AND i.indisprimary
+		ORDER BY array_position(i.indkey::int2[], a.attnum)`, table)
+	if err != nil {
+		return fmt.Errorf("failed to read the primary key of %s: %w", table, err)
+	}
+	var keyFields []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			rows.Close()
+			return err
+		}
+		keyFields = append(keyFields, fmt.Sprintf("'%s', r.%s", strings.ReplaceAll(column, "'", "''"), pgx.Identifier{column}.Sanitize()))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	key := "NULL"
+	if len(keyFields) > 0 {
+		key = "json_build_object(" + strings.Join(keyFields, ", ") + ")"
+	}
+
+	name := changeNotifierName(table)
+	// channel is a validated identifier, so it can be quoted as a literal.
+	return s.PostgresqlExecuteTransaction(ctx, []string{
+		fmt.Sprintf(`CREATE OR REPLACE FUNCTION %s() RETURNS trigger AS $notifier$
+DECLARE
+	r record;
+	payload text;
+BEGIN
+	IF TG_OP = 'DELETE' THEN
+		r := OLD;
+	ELSE
+		r := NEW;
+	END IF;
+	payload := row_to_json(r)::text;
+	IF octet_length(payload) >= %d THEN
+		payload := json_build_object('op', TG_OP, 'key', %s)::text;
+	END IF;
+	PERFORM pg_notify('%s', payload);
+	RETURN r;
+END
+$notifier$ LANGUAGE plpgsql`, name, notifyPayloadLimit, key, channel),
+		fmt.Sprintf("DROP TRIGGER IF EXISTS %s ON %s", name, table),
+		fmt.Sprintf("CREATE TRIGGER %s AFTER INSERT OR UPDATE OR DELETE ON %s FOR EACH ROW EXECUTE FUNCTION %s()", name, table, name),
+	})
+}
+
+// PostgresqlRemoveChangeNotifier drops the trigger and trigger function
+// installed by PostgresqlInstallChangeNotifier.
+func (s *Service) PostgresqlRemoveChangeNotifier(ctx context.Context, table string) error {
+	if err := ValidateIdentifier(table); err != nil {
+		return err
+	}
+	name := changeNotifierName(table)
+	return s.PostgresqlExecuteTransaction(ctx, []string{
+		fmt.Sprintf("DROP TRIGGER IF EXISTS %s ON %s", name, table),
+		fmt.Sprintf("DROP FUNCTION IF EXISTS %s()", name),
+	})
+}
+
+// auditLogTable receives the rows written by the triggers of
+// PostgresqlCreateAuditTrigger.
+const auditLogTable = "audit_log"
+
+// PostgresqlCreateAuditTrigger installs a trigger function log_<table>_changes
+// and a trigger <table>_audit that record every insert, update and delete on
+// table in audit_log, with the old and new rows as JSON. audit_log is
+// created if it does not exist.
+func (s *Service) PostgresqlCreateAuditTrigger(ctx context.Context, table string) error {
+	if err := ValidateIdentifier(table); err != nil {
+		return err
+	}
+	if strings.EqualFold(table, auditLogTable) {
+		return fmt.Errorf("%w: %s cannot audit itself", ErrInvalidName, auditLogTable)
+	}
+	function := "log_" + table + "_changes"
+	return s.PostgresqlExecuteTransaction(ctx, []string{
+		`CREATE TABLE IF NOT EXISTS ` + auditLogTable + ` (
+			id bigserial PRIMARY KEY,
+			timestamp timestamptz NOT NULL DEFAULT now(),
+			table_name text NOT NULL,
+			operation text NOT NULL,
+			old_data jsonb,
+			new_data jsonb
+		)`,
+		fmt.Sprintf(`CREATE OR REPLACE FUNCTION %s() RETURNS trigger AS $$
+BEGIN
+	INSERT INTO %s (table_name, operation, old_data, new_data)
+	VALUES (TG_TABLE_NAME, TG_OP,
+		CASE WHEN TG_OP <> 'INSERT' THEN to_jsonb(OLD) END,
+		CASE WHEN TG_OP <> 'DELETE' THEN to_jsonb(NEW) END);
+	RETURN NULL;
+END
+$$ LANGUAGE plpgsql`, function, auditLogTable),
+		fmt.Sprintf("DROP TRIGGER IF EXISTS %s_audit ON %s", table, table),
+		fmt.Sprintf("CREATE TRIGGER %s_audit AFTER INSERT OR UPDATE OR DELETE ON %s FOR EACH ROW EXECUTE FUNCTION %s()", table, table, function),
+	})
+}
+
+// PostgresqlDropAuditTrigger drops the trigger and trigger function
+// installed by PostgresqlCreateAuditTrigger, keeping audit_log.
+func (s *Service) PostgresqlDropAuditTrigger(ctx context.Context, table string) error {
+	if err := ValidateIdentifier(table); err != nil {
+		return err
+	}
+	return s.PostgresqlExecuteTransaction(ctx, []string{
+		fmt.Sprintf("DROP TRIGGER IF EXISTS %s_audit ON %s", table, table),
+		fmt.Sprintf("DROP FUNCTION IF EXISTS log_%s_changes()", table),
+	})
+}
+
+// PostgresqlListen listens on channel and calls emit with the payload of
+// each notification until ctx is done or emit fails. It holds a dedicated
+// connection rather than one of the pool.
+func (s *Service) PostgresqlListen(ctx context.Context, channel string, emit func(payload string) error) error {
+	if err := ValidateIdentifier(channel); err != nil {
+		return err
+	}
+	if s.PostgresqlURL == "" {
+		return fmt.Errorf("POSTGRESQL_DB_CONNECT_STRING not set")
+	}
+
+	conn, err := pgx.Connect(ctx, s.PostgresqlURL)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	// Quoted, so the channel keeps its case like the pg_notify argument of
+	// PostgresqlInstallChangeNotifier instead of being folded to lowercase.
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", channel, err)
+	}
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		if err := emit(notification.Payload); err != nil {
+			return err
+		}
+	}
+}
+
+// ReplicaLag is the replication progress of a standby as seen from the
+// primary. The LSNs are empty when the role may not read them.
+type ReplicaLag struct {
+	Name      string `json:"name"`
+	State     string `json:"state"`
+	SentLSN   string `json:"sent_lsn"`
+	WriteLSN  string `json:"write_lsn"`
+	FlushLSN  string `json:"flush_lsn"`
+	ReplayLSN string `json:"replay_lsn"`
+	// LagBytes is how far replay_lsn is behind the current WAL position.
+	LagBytes int64 `json:"lag_bytes"`
+}
+
+// WALStatus is the replication state of the server. A primary reports its
+// Replicas; a replica reports how far its replay is behind the WAL it
+// received.
+type WALStatus struct {
+	IsReplica      bool         `json:"is_replica"`
+	Replicas       []ReplicaLag `json:"replicas,omitempty"`
+	ReceiveLSN     string       `json:"receive_lsn,omitempty"`
+	ReplayLSN      string       `json:"replay_lsn,omitempty"`
+	ReplayLagBytes int64        `json:"replay_lag_bytes"`
+}
+
+// PostgresqlWalStatus returns the replication state of the server, reading
+// pg_stat_replication on a primary and the last received and replayed
+// LSNs on a replica.
+func (s *Service) PostgresqlWalStatus(ctx context.Context) (*WALStatus, error) {
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return nil, err
+	}
+
+	status := &WALStatus{}
+	if err := db.QueryRowContext(ctx, "SELECT pg_is_in_recovery()").Scan(&status.IsReplica); err != nil {
+		return nil, err
+	}
+	if status.IsReplica {
+		var receiveLSN, replayLSN sql.NullString
+		err := db.QueryRowContext(ctx, `
+			SELECT pg_last_wal_receive_lsn()::text, pg_last_wal_replay_lsn()::text,
+				coalesce(pg_wal_lsn_diff(pg_last_wal_receive_lsn(), pg_last_wal_replay_lsn()), 0)::bigint`,
+		).Scan(&receiveLSN, &replayLSN, &status.ReplayLagBytes)
+		if err != nil {
+			return nil, err
+		}
+		status.ReceiveLSN, status.ReplayLSN = receiveLSN.String, replayLSN.String
+		return status, nil
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT application_name, coalesce(state, ''),
+			coalesce(sent_lsn::text, ''), coalesce(write_lsn::text, ''),
+			coalesce(flush_lsn::text, ''), coalesce(replay_lsn::text, ''),
+			coalesce(pg_wal_lsn_diff(pg_current_wal_lsn(), replay_lsn), 0)::bigint
+		FROM pg_stat_replication
+		ORDER BY application_name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	status.Replicas = []ReplicaLag{}
+	for rows.Next() {
+		var replica ReplicaLag
+		if err := rows.Scan(&replica.Name, &replica.State, &replica.SentLSN, &replica.WriteLSN,
+			&replica.FlushLSN, &replica.ReplayLSN, &replica.LagBytes); err != nil {
+			return nil, err
+		}
+		status.Replicas = append(status.Replicas, replica)
+	}
+	return status, rows.Err()
+}
+
+// SSLStatus is the encryption state of a database connection.
+type SSLStatus struct {
+	SSL        bool   `json:"ssl"`
+	TLSVersion string `json:"tls_version"`
+	Cipher     string `json:"cipher"`
+	Bits       int    `json:"bits"`
+}
+
+// PostgresqlSSLStatus returns the encryption state of one of the pooled
+// connections, as seen by the server in pg_stat_ssl.
+func (s *Service) PostgresqlSSLStatus(ctx context.Context) (*SSLStatus, error) {
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return nil, err
+	}
+
+	status := &SSLStatus{}
+	err = db.QueryRowContext(ctx, `
+		SELECT ssl, coalesce(version, ''), coalesce(cipher, ''), coalesce(bits, 0)
+		FROM pg_stat_ssl
+		WHERE pid = pg_backend_pid()`,
+	).Scan(&status.SSL, &status.TLSVersion, &status.Cipher, &status.Bits)
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
@@ -0,0 +1,47 @@
+// Copyright 2025 Canonical Ltd.
+// See LICENSE file for licensing details.
+
+package service
+
+import (
+	"testing"
+)
+
+func TestPlannedStatement(t *testing.T) {
+	tests := []struct {
+		query     string
+		statement string
+		ok        bool
+	}{
+		{"SELECT 1", "SELECT 1", true},
+		{"  (select 1)", "(select 1)", true},
+		{"WITH x AS (SELECT 1) SELECT * FROM x", "WITH x AS (SELECT 1) SELECT * FROM x", true},
+		{"DELETE FROM t", "DELETE FROM t", true},
+		{"/* x */ SELECT 1", "SELECT 1", true},
+		{"/* a /* nested */ comment */SELECT 1", "SELECT 1", true},
+		{"-- x\nSELECT 1", "SELECT 1", true},
+		{"-- x\n/* y */\n  SELECT 1", "SELECT 1", true},
+		{"EXPLAIN ANALYZE SELECT 1", "SELECT 1", true},
+		{"explain (analyze, buffers) verbose select 1", "select 1", true},
+		{"EXPLAIN /* x */ SELECT 1", "SELECT 1", true},
+		{"EXPLAIN /* x */ ANALYZE SELECT 1", "ANALYZE SELECT 1", false},
+		{"CREATE TABLE t AS SELECT 1", "CREATE TABLE t AS SELECT 1", true},
+		{"CREATE TEMP TABLE t AS SELECT 1", "CREATE TEMP TABLE t AS SELECT 1", true},
+		{"CREATE MATERIALIZED VIEW v AS SELECT 1", "CREATE MATERIALIZED VIEW v AS SELECT 1", true},
+		{"DECLARE c CURSOR FOR SELECT 1", "DECLARE c CURSOR FOR SELECT 1", true},
+		{"EXECUTE stmt(1)", "EXECUTE stmt(1)", true},
+		{"CREATE TABLE t (id int)", "CREATE TABLE t (id int)", false},
+		{"SET search_path TO x", "SET search_path TO x", false},
+		{"/* unterminated SELECT 1", "", false},
+		{"-- only a comment", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			statement, ok := plannedStatement(tt.query)
+			if statement != tt.statement || ok != tt.ok {
+				t.Errorf("plannedStatement(%q) = %q, %v, want %q, %v", tt.query, statement, ok, tt.statement, tt.ok)
+			}
+		})
+	}
+}
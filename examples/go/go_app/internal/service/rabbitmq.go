@@ -0,0 +1,1119 @@
+// Copyright 2025 Canonical Ltd.
+// See LICENSE file for licensing details.
+
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ErrNoMessage is returned when a queue has no message to fetch.
+var ErrNoMessage = errors.New("no message available")
+
+// ErrQueueConflict is returned when a queue already exists with different
+// settings than the ones being declared.
+var ErrQueueConflict = errors.New("queue exists with different settings")
+
+// ErrExchangeNotFound is returned when publishing to an exchange that does
+// not exist.
+var ErrExchangeNotFound = errors.New("exchange not found")
+
+// ErrQueueNotFound is returned when a queue that must already exist does
+// not.
+var ErrQueueNotFound = errors.New("queue not found")
+
+// ErrExchangeInUse is returned when an exchange deleted with ifUnused still
+// has bindings.
+var ErrExchangeInUse = errors.New("exchange is in use")
+
+var queueNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.:-]{1,255}$`)
+
+// ValidateQueueName checks that name is a usable, non reserved queue name.
+func ValidateQueueName(name string) error {
+	if !queueNamePattern.MatchString(name) || strings.HasPrefix(name, "amq.") {
+		return fmt.Errorf("%w: queue %q", ErrInvalidName, name)
+	}
+	return nil
+}
+
+// ValidateExchangeName checks that name is a usable exchange name. Unlike
+// queues, the predeclared amq.* exchanges may be published to.
+func ValidateExchangeName(name string) error {
+	if !queueNamePattern.MatchString(name) {
+		return fmt.Errorf("%w: exchange %q", ErrInvalidName, name)
+	}
+	return nil
+}
+
+// RabbitMQMessage is a message to publish.
+type RabbitMQMessage struct {
+	Body []byte
+	// ContentType defaults to text/plain.
+	ContentType string
+	// Persistent messages are written to disk by durable queues and
+	// survive a broker restart.
+	Persistent bool
+	// ExpirationMs is how long the message may wait in a queue before the
+	// broker discards it; zero means it does not expire.
+	ExpirationMs int
+}
+
+// publishing converts m to the AMQP publishing it is sent as.
+func (m RabbitMQMessage) publishing() amqp.Publishing {
+	contentType := m.ContentType
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	deliveryMode := amqp.Transient
+	if m.Persistent {
+		deliveryMode = amqp.Persistent
+	}
+	expiration := ""
+	if m.ExpirationMs > 0 {
+		expiration = strconv.Itoa(m.ExpirationMs)
+	}
+	return amqp.Publishing{
+		ContentType:  contentType,
+		DeliveryMode: deliveryMode,
+		Expiration:   expiration,
+		Body:         m.Body,
+	}
+}
+
+// RabbitMQPublishToExchange publishes msg to exchange with routingKey. The
+// exchange must already exist.
+func (s *Service) RabbitMQPublishToExchange(ctx context.Context, exchange, routingKey string, msg RabbitMQMessage) error {
+	if err := ValidateExchangeName(exchange); err != nil {
+		return err
+	}
+	if len(routingKey) > 255 {
+		return fmt.Errorf("%w: routing key longer than 255 bytes", ErrInvalidName)
+	}
+
+	ch, err := s.Channel()
+	if err != nil {
+		return err
+	}
+	defer s.Release(ch)
+
+	// Publishing to a missing exchange only closes the channel
+	// asynchronously, check it exists first to report the error.
+	err = ch.ExchangeDeclarePassive(exchange, "direct", false, false, false, false, nil)
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) && amqpErr.Code == amqp.NotFound {
+		return fmt.Errorf("%w: %s", ErrExchangeNotFound, exchange)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check exchange %s: %w", exchange, err)
+	}
+
+	return ch.PublishWithContext(ctx, exchange, routingKey, false, false, msg.publishing())
+}
+
+// RabbitMQPublishPersistent declares queue as a durable queue and publishes
+// body to it as a persistent message. RabbitMQ refuses to redeclare an
+// existing transient queue as durable.
+func (s *Service) RabbitMQPublishPersistent(ctx context.Context, queue string, body []byte) error {
+	if err := ValidateQueueName(queue); err != nil {
+		return err
+	}
+
+	ch, err := s.Channel()
+	if err != nil {
+		return err
+	}
+	defer s.Release(ch)
+
+	_, err = ch.QueueDeclare(queue, true, false, false, false, nil)
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) && amqpErr.Code == amqp.PreconditionFailed {
+		return fmt.Errorf("%w: %s", ErrQueueConflict, queue)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to declare queue %s: %w", queue, err)
+	}
+
+	return ch.PublishWithContext(ctx, "", queue, false, false, RabbitMQMessage{Body: body, Persistent: true}.publishing())
+}
+
+// RabbitMQPublishWithExpiry declares queue as a transient queue, as
+// RabbitMQSend does for "charm", and publishes body to it with a
+// per-message TTL of ttl, rounded down to the millisecond.
+func (s *Service) RabbitMQPublishWithExpiry(ctx context.Context, queue string, body []byte, ttl time.Duration) error {
+	if err := ValidateQueueName(queue); err != nil {
+		return err
+	}
+	if ttl < time.Millisecond {
+		return fmt.Errorf("message TTL must be at least 1ms, got %s", ttl)
+	}
+
+	ch, err := s.Channel()
+	if err != nil {
+		return err
+	}
+	defer s.Release(ch)
+
+	_, err = ch.QueueDeclare(queue, false, false, false, false, nil)
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) && amqpErr.Code == amqp.PreconditionFailed {
+		return fmt.Errorf("%w: %s", ErrQueueConflict, queue)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to declare queue %s: %w", queue, err)
+	}
+
+	msg := RabbitMQMessage{Body: body, ExpirationMs: int(ttl.Milliseconds())}
+	return ch.PublishWithContext(ctx, "", queue, false, false, msg.publishing())
+}
+
+// MaxPriority is the x-max-priority of the queues declared by
+// RabbitMQPublishWithPriority.
+const MaxPriority = 10
+
+// RabbitMQPublishWithPriority declares queue as a priority queue, with
+// MaxPriority priority levels, and publishes body to it with priority.
+// RabbitMQ refuses to redeclare an existing queue without x-max-priority
+// as a priority queue.
+func (s *Service) RabbitMQPublishWithPriority(ctx context.Context, queue string, body []byte, priority uint8) error {
+	if err := ValidateQueueName(queue); err != nil {
+		return err
+	}
+	if priority > MaxPriority {
+		return fmt.Errorf("priority %d above the maximum of %d", priority, MaxPriority)
+	}
+
+	ch, err := s.Channel()
+	if err != nil {
+		return err
+	}
+	defer s.Release(ch)
+
+	_, err = ch.QueueDeclare(queue, false, false, false, false, amqp.Table{"x-max-priority": MaxPriority})
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) && amqpErr.Code == amqp.PreconditionFailed {
+		return fmt.Errorf("%w: %s", ErrQueueConflict, queue)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to declare queue %s: %w", queue, err)
+	}
+
+	msg := RabbitMQMessage{Body: body}.publishing()
+	msg.Priority = priority
+	return ch.PublishWithContext(ctx, "", queue, false, false, msg)
+}
+
+// RoutedMessage is a message to publish to an exchange with RoutingKey.
+type RoutedMessage struct {
+	RabbitMQMessage
+	RoutingKey string
+}
+
+// BatchPublishResult lists the indices of the messages of a batch the
+// broker confirmed and rejected.
+type BatchPublishResult struct {
+	ACKed  []int `json:"acked"`
+	NACKed []int `json:"nacked"`
+}
+
+// RabbitMQPublishBatchToExchange publishes msgs to exchange on a confirm
+// mode channel and waits for the confirmation of the whole batch. The
+// exchange must already exist. An error is only returned when the batch
+// could not be published; messages the broker rejected are listed in
+// NACKed.
+func (s *Service) RabbitMQPublishBatchToExchange(ctx context.Context, exchange string, msgs []RoutedMessage) (*BatchPublishResult, error) {
+	if err := ValidateExchangeName(exchange); err != nil {
+		return nil, err
+	}
+	for _, msg := range msgs {
+		if len(msg.RoutingKey) > 255 {
+			return nil, fmt.Errorf("%w: routing key longer than 255 bytes", ErrInvalidName)
+		}
+	}
+
+	// Confirm mode cannot be turned off again, keep it off the pool.
+	ch, err := s.Channel()
+	if err != nil {
+		return nil, err
+	}
+	defer ch.Close()
+
+	err = ch.ExchangeDeclarePassive(exchange, "direct", false, false, false, false, nil)
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) && amqpErr.Code == amqp.NotFound {
+		return nil, fmt.Errorf("%w: %s", ErrExchangeNotFound, exchange)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to check exchange %s: %w", exchange, err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		return nil, fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+
+	confirmations := make([]*amqp.DeferredConfirmation, 0, len(msgs))
+	for i, msg := range msgs {
+		confirmation, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, msg.RoutingKey, false, false, msg.publishing())
+		if err != nil {
+			return nil, fmt.Errorf("failed to publish message %d to %s: %w", i, exchange, err)
+		}
+		confirmations = append(confirmations, confirmation)
+	}
+
+	result := &BatchPublishResult{ACKed: []int{}, NACKed: []int{}}
+	for i, confirmation := range confirmations {
+		acked, err := confirmation.WaitContext(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to confirm batch publish to %s: %w", exchange, err)
+		}
+		if acked {
+			result.ACKed = append(result.ACKed, i)
+		} else {
+			result.NACKed = append(result.NACKed, i)
+		}
+	}
+	return result, nil
+}
+
+// MultiPublishError is returned by RabbitMQPublishToMultipleQueues when the
+// message could not be delivered to some of the queues.
+type MultiPublishError struct {
+	Succeeded []string `json:"succeeded"`
+	Failed    []string `json:"failed"`
+}
+
+func (e *MultiPublishError) Error() string {
+	return fmt.Sprintf("message not delivered to queues %s", strings.Join(e.Failed, ", "))
+}
+
+// RabbitMQPublishToMultipleQueues publishes msg to each of queues through
+// the default exchange on a single confirm mode channel. Messages are
+// published as mandatory so a missing queue is reported by the broker
+// instead of the message being dropped; a *MultiPublishError lists the
+// queues the message was returned or nacked for.
+func (s *Service) RabbitMQPublishToMultipleQueues(ctx context.Context, queues []string, msg RabbitMQMessage) error {
+	for _, queue := range queues {
+		if err := ValidateQueueName(queue); err != nil {
+			return err
+		}
+	}
+
+	// Confirm mode cannot be turned off again, keep it off the pool.
+	ch, err := s.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	// A message is returned before it is confirmed, so every return has
+	// been received once all confirmations are.
+	returns := ch.NotifyReturn(make(chan amqp.Return, len(queues)))
+	if err := ch.Confirm(false); err != nil {
+		return fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+
+	confirmations := make([]*amqp.DeferredConfirmation, 0, len(queues))
+	for _, queue := range queues {
+		confirmation, err := ch.PublishWithDeferredConfirmWithContext(ctx, "", queue, true, false, msg.publishing())
+		if err != nil {
+			return fmt.Errorf("failed to publish to %s: %w", queue, err)
+		}
+		confirmations = append(confirmations, confirmation)
+	}
+
+	failed := map[string]bool{}
+	for i, confirmation := range confirmations {
+		acked, err := confirmation.WaitContext(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to confirm publish to %s: %w", queues[i], err)
+		}
+		if !acked {
+			failed[queues[i]] = true
+		}
+	}
+	for len(returns) > 0 {
+		failed[(<-returns).RoutingKey] = true
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+
+	multiErr := &MultiPublishError{Succeeded: []string{}, Failed: []string{}}
+	for _, queue := range queues {
+		if failed[queue] {
+			multiErr.Failed = append(multiErr.Failed, queue)
+		} else {
+			multiErr.Succeeded = append(multiErr.Succeeded, queue)
+		}
+	}
+	return multiErr
+}
+
+// RabbitMQDeclareExclusiveQueue declares name as a quorum queue with single
+// active consumer enabled, so the broker delivers to one consumer at a time
+// and fails over to the next one when it goes away. Quorum queues are
+// always durable.
+func (s *Service) RabbitMQDeclareExclusiveQueue(ctx context.Context, name string) error {
+	if err := ValidateQueueName(name); err != nil {
+		return err
+	}
+
+	ch, err := s.Channel()
+	if err != nil {
+		return err
+	}
+	defer s.Release(ch)
+
+	return declareExclusiveQueue(ch, name)
+}
+
+// queueDeclarer is the part of *amqp.Channel that declares queues.
+type queueDeclarer interface {
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+}
+
+// declareExclusiveQueue declares the single active consumer queue name on
+// ch. The queue is neither AMQP exclusive nor auto-delete: quorum queues
+// support neither, and either would tie the queue to the lifetime of one
+// connection or consumer.
+func declareExclusiveQueue(ch queueDeclarer, name string) error {
+	_, err := ch.QueueDeclare(name, true, false, false, false, amqp.Table{
+		"x-queue-type":             "quorum",
+		"x-single-active-consumer": true,
+	})
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) && amqpErr.Code == amqp.PreconditionFailed {
+		return fmt.Errorf("%w: %s", ErrQueueConflict, amqpErr.Reason)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to declare queue %s: %w", name, err)
+	}
+	return nil
+}
+
+// RabbitMQDeleteQueue deletes the named queue and returns the number of
+// messages that were purged with it. It returns ErrQueueConflict when
+// ifUnused or ifEmpty prevent the deletion.
+func (s *Service) RabbitMQDeleteQueue(ctx context.Context, name string, ifUnused, ifEmpty bool) (int, error) {
+	if err := ValidateQueueName(name); err != nil {
+		return 0, err
+	}
+
+	ch, err := s.Channel()
+	if err != nil {
+		return 0, err
+	}
+	defer s.Release(ch)
+
+	purged, err := ch.QueueDelete(name, ifUnused, ifEmpty, false)
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) && amqpErr.Code == amqp.PreconditionFailed {
+		return 0, fmt.Errorf("%w: %s", ErrQueueConflict, amqpErr.Reason)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete queue %s: %w", name, err)
+	}
+	return purged, nil
+}
+
+// RabbitMQDeleteExchange deletes the named exchange; with ifUnused it is
+// only deleted when no queue or exchange is bound to it, and
+// ErrExchangeInUse is returned otherwise. The default
+// exchange and the predeclared amq.* exchanges cannot be deleted.
+func (s *Service) RabbitMQDeleteExchange(ctx context.Context, name string, ifUnused bool) error {
+	if name == "" || strings.HasPrefix(name, "amq.") {
+		return fmt.Errorf("%w: exchange %q is predeclared and cannot be deleted", ErrInvalidName, name)
+	}
+	if err := ValidateExchangeName(name); err != nil {
+		return err
+	}
+
+	ch, err := s.Channel()
+	if err != nil {
+		return err
+	}
+	defer s.Release(ch)
+
+	err = ch.ExchangeDelete(name, ifUnused, false)
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) && amqpErr.Code == amqp.PreconditionFailed {
+		return fmt.Errorf("%w: %s", ErrExchangeInUse, amqpErr.Reason)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete exchange %s: %w", name, err)
+	}
+	return nil
+}
+
+// ErrPartialPurge is returned by RabbitMQClearAllQueues when some queues
+// were purged and others were not.
+var ErrPartialPurge = errors.New("not all queues were purged")
+
+// RabbitMQClearAllQueues purges every queue of the configured vhost and
+// returns how many messages were purged per queue. Queues that cannot be
+// purged are left out of the result and reported in the returned error,
+// which then wraps ErrPartialPurge.
+func (s *Service) RabbitMQClearAllQueues(ctx context.Context) (map[string]int, error) {
+	queues, err := s.RabbitMQListQueues(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	purged := make(map[string]int, len(queues))
+	var errs []error
+	for _, queue := range queues {
+		ch, err := s.Channel()
+		if err != nil {
+			return purged, fmt.Errorf("%w: %w", ErrPartialPurge, errors.Join(append(errs, err)...))
+		}
+		count, err := ch.QueuePurge(queue, false)
+		if err != nil {
+			// The broker closed the channel, keep it off the pool.
+			ch.Close()
+			errs = append(errs, fmt.Errorf("failed to purge queue %s: %w", queue, err))
+			continue
+		}
+		s.Release(ch)
+		purged[queue] = count
+	}
+	if len(errs) > 0 {
+		return purged, fmt.Errorf("%w: %w", ErrPartialPurge, errors.Join(errs...))
+	}
+	return purged, nil
+}
+
+// RawMessage is a fetched AMQP message including its metadata.
+type RawMessage struct {
+	Body        string                 `json:"body"`
+	ContentType string                 `json:"content_type"`
+	Headers     map[string]interface{} `json:"headers"`
+	DeliveryTag uint64                 `json:"delivery_tag"`
+	Redelivered bool                   `json:"redelivered"`
+}
+
+// RabbitMQGetMessage fetches a single message from queue. The message is
+// acknowledged when ack is true and requeued otherwise.
+func (s *Service) RabbitMQGetMessage(ctx context.Context, queue string, ack bool) (*RawMessage, error) {
+	if err := ValidateQueueName(queue); err != nil {
+		return nil, err
+	}
+
+	ch, err := s.Channel()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Release(ch)
+
+	msg, ok, err := ch.Get(queue, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message from %s: %w", queue, err)
+	}
+	if !ok {
+		return nil, ErrNoMessage
+	}
+
+	if ack {
+		err = msg.Ack(false)
+	} else {
+		err = msg.Nack(false, true)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to settle message: %w", err)
+	}
+
+	headers := map[string]interface{}(msg.Headers)
+	if headers == nil {
+		headers = map[string]interface{}{}
+	}
+	return &RawMessage{
+		Body:        string(msg.Body),
+		ContentType: msg.ContentType,
+		Headers:     headers,
+		DeliveryTag: msg.DeliveryTag,
+		Redelivered: msg.Redelivered,
+	}, nil
+}
+
+// RabbitMQNackAll takes up to max ready messages of queue on a dedicated
+// channel and negatively acknowledges all of them at once with a single
+// multiple nack on delivery tag 0. With requeue the messages go back to the
+// queue marked as redelivered; without it they are dropped or dead-lettered.
+// It returns the number of messages nacked and the number still ready in
+// the queue when it stopped taking messages.
+func (s *Service) RabbitMQNackAll(ctx context.Context, queue string, requeue bool, max int) (int, int, error) {
+	if err := ValidateQueueName(queue); err != nil {
+		return 0, 0, err
+	}
+
+	ch, err := s.Channel()
+	if err != nil {
+		return 0, 0, err
+	}
+	// Closing rather than releasing requeues whatever is still unacked if
+	// we bail out half way.
+	defer ch.Close()
+
+	count, remaining := 0, 0
+	for count < max && ctx.Err() == nil {
+		msg, ok, err := ch.Get(queue, false)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to get message from %s: %w", queue, err)
+		}
+		if !ok {
+			remaining = 0
+			break
+		}
+		count++
+		remaining = int(msg.MessageCount)
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, 0, err
+	}
+	if count == 0 {
+		return 0, remaining, nil
+	}
+
+	if err := ch.Nack(0, true, requeue); err != nil {
+		return 0, 0, fmt.Errorf("failed to nack messages: %w", err)
+	}
+	return count, remaining, nil
+}
+
+// priorityConsumerPrefetch is the prefetch count of priority consumers. A
+// consumer only counts as busy, letting lower priority ones receive
+// messages, once it holds this many unacknowledged deliveries.
+const priorityConsumerPrefetch = 1
+
+// ErrConsumerNotFound is returned when no priority consumer has a tag.
+var ErrConsumerNotFound = errors.New("consumer not found")
+
+// RabbitMQConsumer is a consumer started with RabbitMQStartPriorityConsumer
+// or RabbitMQConsumeWithPriority.
+type RabbitMQConsumer struct {
+	Tag   string `json:"tag"`
+	Queue string `json:"queue"`
+	// Priority is the x-priority of the consumer, 0 by default.
+	Priority int `json:"priority"`
+}
+
+// priorityConsumer is a running priority consumer. Its Priority is guarded
+// by Service.mu.
+type priorityConsumer struct {
+	RabbitMQConsumer
+	ch           *amqp.Channel
+	deliveries   <-chan amqp.Delivery
+	handler      func(amqp.Delivery) error
+	cancel       context.CancelFunc
+	reprioritize chan priorityChange
+	// done is closed once the consumer stopped.
+	done chan struct{}
+}
+
+// priorityChange asks a priority consumer to re-register with priority.
+type priorityChange struct {
+	priority int
+	result   chan error
+}
+
+var priorityConsumerSeq atomic.Uint64
+
+// startPriorityConsumer registers a consumer of queue with priority on a
+// dedicated channel, and tracks it by its tag until ctx is done.
+func (s *Service) startPriorityConsumer(ctx context.Context, queue string, priority int, handler func(amqp.Delivery) error) (*priorityConsumer, context.Context, error) {
+	if err := ValidateQueueName(queue); err != nil {
+		return nil, nil, err
+	}
+
+	ch, err := s.Channel()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := ch.Qos(priorityConsumerPrefetch, 0, false); err != nil {
+		ch.Close()
+		return nil, nil, fmt.Errorf("failed to set prefetch: %w", err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	pc := &priorityConsumer{
+		RabbitMQConsumer: RabbitMQConsumer{
+			Tag:      fmt.Sprintf("priority-%d", priorityConsumerSeq.Add(1)),
+			Queue:    queue,
+			Priority: priority,
+		},
+		ch:           ch,
+		handler:      handler,
+		cancel:       cancel,
+		reprioritize: make(chan priorityChange),
+		done:         make(chan struct{}),
+	}
+	if pc.deliveries, err = pc.consume(ctx, priority); err != nil {
+		cancel()
+		ch.Close()
+		return nil, nil, err
+	}
+
+	s.mu.Lock()
+	if s.priorityConsumers == nil {
+		s.priorityConsumers = make(map[string]*priorityConsumer)
+	}
+	s.priorityConsumers[pc.Tag] = pc
+	s.mu.Unlock()
+	return pc, ctx, nil
+}
+
+func (pc *priorityConsumer) consume(ctx context.Context, priority int) (<-chan amqp.Delivery, error) {
+	deliveries, err := pc.ch.ConsumeWithContext(ctx, pc.Queue, pc.Tag, false, false, false, false, amqp.Table{"x-priority": int32(priority)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume %s: %w", pc.Queue, err)
+	}
+	return deliveries, nil
+}
+
+// handle calls the handler of pc for delivery and acknowledges it, or
+// requeues it when the handler fails.
+func (pc *priorityConsumer) handle(delivery amqp.Delivery) error {
+	if err := pc.handler(delivery); err != nil {
+		if nackErr := delivery.Nack(false, true); nackErr != nil {
+			log.Printf("Failed to requeue delivery from %s: %v", pc.Queue, nackErr)
+		}
+		return err
+	}
+	if err := delivery.Ack(false); err != nil {
+		return fmt.Errorf("failed to ack delivery from %s: %w", pc.Queue, err)
+	}
+	return nil
+}
+
+// runPriorityConsumer delivers to the handler of pc until ctx is done or the handler fails,
+// applying priority changes on the way, then stops the consumer.
+func (s *Service) runPriorityConsumer(ctx context.Context, pc *priorityConsumer) error {
+	defer func() {
+		s.mu.Lock()
+		delete(s.priorityConsumers, pc.Tag)
+		s.mu.Unlock()
+		pc.cancel()
+		// Closing cancels the consumer and requeues unacknowledged
+		// deliveries.
+		pc.ch.Close()
+		close(pc.done)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case change := <-pc.reprioritize:
+			err := s.changeConsumerPriority(ctx, pc, change.priority)
+			change.result <- err
+			if err != nil {
+				return err
+			}
+		case delivery, ok := <-pc.deliveries:
+			if !ok {
+				return fmt.Errorf("consumer of %s closed", pc.Queue)
+			}
+			if err := pc.handle(delivery); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// changeConsumerPriority cancels the consumer of pc and registers it again
+// with priority, since a consumer priority cannot change in place.
+func (s *Service) changeConsumerPriority(ctx context.Context, pc *priorityConsumer, priority int) error {
+	if err := pc.ch.Cancel(pc.Tag, false); err != nil {
+		return fmt.Errorf("failed to cancel consumer %s: %w", pc.Tag, err)
+	}
+	// Deliveries sent before the cancel still arrive, and can be acked.
+	for delivery := range pc.deliveries {
+		if err := pc.handle(delivery); err != nil {
+			return err
+		}
+	}
+	deliveries, err := pc.consume(ctx, priority)
+	if err != nil {
+		return err
+	}
+	pc.deliveries = deliveries
+
+	s.mu.Lock()
+	pc.Priority = priority
+	s.mu.Unlock()
+	return nil
+}
+
+// RabbitMQConsumeWithPriority consumes queue with the consumer priority
+// priority and calls handler for each delivery until ctx is done or handler
+// fails. The broker delivers to lower priority consumers of the queue only
+// while the higher priority ones are busy, that is hold
+// priorityConsumerPrefetch unacknowledged deliveries. Deliveries are
+// acknowledged once handler succeeds; the one it fails on is requeued and
+// its error returned. The priority can be changed with
+// RabbitMQSetConsumerPriority while it runs.
+func (s *Service) RabbitMQConsumeWithPriority(ctx context.Context, queue string, priority int, handler func(amqp.Delivery) error) error {
+	pc, ctx, err := s.startPriorityConsumer(ctx, queue, priority, handler)
+	if err != nil {
+		return err
+	}
+	return s.runPriorityConsumer(ctx, pc)
+}
+
+// RabbitMQStartPriorityConsumer runs RabbitMQConsumeWithPriority in the
+// background until RabbitMQCancelConsumer or Close, and returns the
+// consumer.
+func (s *Service) RabbitMQStartPriorityConsumer(queue string, priority int, handler func(amqp.Delivery) error) (RabbitMQConsumer, error) {
+	pc, ctx, err := s.startPriorityConsumer(context.Background(), queue, priority, handler)
+	if err != nil {
+		return RabbitMQConsumer{}, err
+	}
+	go func() {
+		if err := s.runPriorityConsumer(ctx, pc); err != nil && ctx.Err() == nil {
+			log.Printf("Priority consumer %s of %s stopped: %v", pc.Tag, queue, err)
+		}
+	}()
+	return pc.RabbitMQConsumer, nil
+}
+
+// priorityConsumer returns the running priority consumer tag.
+func (s *Service) priorityConsumer(tag string) (*priorityConsumer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pc, ok := s.priorityConsumers[tag]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrConsumerNotFound, tag)
+	}
+	return pc, nil
+}
+
+// RabbitMQSetConsumerPriority changes the priority of the priority consumer
+// tag by cancelling and re-registering it. Deliveries it already received
+// are handled first.
+func (s *Service) RabbitMQSetConsumerPriority(ctx context.Context, tag string, priority int) error {
+	pc, err := s.priorityConsumer(tag)
+	if err != nil {
+		return err
+	}
+
+	change := priorityChange{priority: priority, result: make(chan error, 1)}
+	select {
+	case pc.reprioritize <- change:
+	case <-pc.done:
+		return fmt.Errorf("%w: %s", ErrConsumerNotFound, tag)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-change.result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RabbitMQCancelConsumer stops the priority consumer tag and waits until
+// its unacknowledged deliveries are requeued.
+func (s *Service) RabbitMQCancelConsumer(ctx context.Context, tag string) error {
+	pc, err := s.priorityConsumer(tag)
+	if err != nil {
+		return err
+	}
+	pc.cancel()
+	select {
+	case <-pc.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RabbitMQPurgeAndRepublish moves up to max messages from sourceQueue to
+// destQueue and returns how many were moved. Each message is acked on the
+// source only once the broker confirmed its copy on the destination; when
+// that fails the message is requeued on the source and moving stops. A
+// missing source or destination queue is reported as ErrQueueNotFound.
+func (s *Service) RabbitMQPurgeAndRepublish(ctx context.Context, sourceQueue, destQueue string, max int) (int, error) {
+	for _, queue := range []string{sourceQueue, destQueue} {
+		if err := ValidateQueueName(queue); err != nil {
+			return 0, err
+		}
+	}
+
+	source, err := s.Channel()
+	if err != nil {
+		return 0, err
+	}
+	defer source.Close()
+	// Confirm mode cannot be turned off again, keep it off the pool.
+	dest, err := s.Channel()
+	if err != nil {
+		return 0, err
+	}
+	defer dest.Close()
+
+	// The default exchange silently drops, and still confirms, messages
+	// for a queue that does not exist.
+	_, err = dest.QueueDeclarePassive(destQueue, false, false, false, false, nil)
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) && amqpErr.Code == amqp.NotFound {
+		return 0, fmt.Errorf("%w: %s", ErrQueueNotFound, destQueue)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to check queue %s: %w", destQueue, err)
+	}
+	if err := dest.Confirm(false); err != nil {
+		return 0, fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+
+	moved := 0
+	for moved < max {
+		msg, ok, err := source.Get(sourceQueue, false)
+		if errors.As(err, &amqpErr) && amqpErr.Code == amqp.NotFound {
+			return moved, fmt.Errorf("%w: %s", ErrQueueNotFound, sourceQueue)
+		}
+		if err != nil {
+			return moved, fmt.Errorf("failed to get message from %s: %w", sourceQueue, err)
+		}
+		if !ok {
+			break
+		}
+
+		if err := republish(ctx, dest, destQueue, msg); err != nil {
+			if nackErr := msg.Nack(false, true); nackErr != nil {
+				err = errors.Join(err, nackErr)
+			}
+			return moved, err
+		}
+		if err := msg.Ack(false); err != nil {
+			return moved, fmt.Errorf("failed to ack message on %s: %w", sourceQueue, err)
+		}
+		moved++
+	}
+	return moved, nil
+}
+
+// republish publishes a copy of msg to queue on the confirm mode channel ch
+// and waits for the broker to confirm it.
+func republish(ctx context.Context, ch *amqp.Channel, queue string, msg amqp.Delivery) error {
+	return publishConfirmed(ctx, ch, queue, amqp.Publishing{
+		Headers:         msg.Headers,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		DeliveryMode:    msg.DeliveryMode,
+		Priority:        msg.Priority,
+		CorrelationId:   msg.CorrelationId,
+		ReplyTo:         msg.ReplyTo,
+		MessageId:       msg.MessageId,
+		Timestamp:       msg.Timestamp,
+		Type:            msg.Type,
+		AppId:           msg.AppId,
+		Body:            msg.Body,
+	})
+}
+
+// publishConfirmed publishes msg to queue on the confirm mode channel ch and
+// waits for the broker to confirm it.
+func publishConfirmed(ctx context.Context, ch *amqp.Channel, queue string, msg amqp.Publishing) error {
+	confirmation, err := ch.PublishWithDeferredConfirmWithContext(ctx, "", queue, false, false, msg)
+	if err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", queue, err)
+	}
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to confirm publish to %s: %w", queue, err)
+	}
+	if !acked {
+		return fmt.Errorf("broker nacked publish to %s", queue)
+	}
+	return nil
+}
+
+// RabbitMQPublishConfirmed publishes body to queue and returns once the
+// broker confirmed the message.
+func (s *Service) RabbitMQPublishConfirmed(ctx context.Context, queue string, body []byte) error {
+	if err := ValidateQueueName(queue); err != nil {
+		return err
+	}
+
+	// Confirm mode cannot be turned off again, keep it off the pool.
+	ch, err := s.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	if _, err := ch.QueueDeclarePassive(queue, false, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to check queue %s: %w", queue, err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		return fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+	return publishConfirmed(ctx, ch, queue, RabbitMQMessage{Body: body}.publishing())
+}
+
+// RabbitMQPublishBatchConfirmed publishes bodies to queue on a single
+// confirm channel and waits for all the broker confirmations. It returns the
+// outcome of each message, nil once it was confirmed, or an error when the
+// batch could not be started.
+func (s *Service) RabbitMQPublishBatchConfirmed(ctx context.Context, queue string, bodies [][]byte) ([]error, error) {
+	if err := ValidateQueueName(queue); err != nil {
+		return nil, err
+	}
+
+	// Confirm mode cannot be turned off again, keep it off the pool.
+	ch, err := s.Channel()
+	if err != nil {
+		return nil, err
+	}
+	defer ch.Close()
+
+	if _, err := ch.QueueDeclarePassive(queue, false, false, false, false, nil); err != nil {
+		return nil, fmt.Errorf("failed to check queue %s: %w", queue, err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		return nil, fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+
+	results := make([]error, len(bodies))
+	confirmations := make([]*amqp.DeferredConfirmation, len(bodies))
+	for i, body := range bodies {
+		confirmations[i], results[i] = ch.PublishWithDeferredConfirmWithContext(ctx, "", queue, false, false,
+			RabbitMQMessage{Body: body}.publishing())
+		if results[i] != nil {
+			results[i] = fmt.Errorf("failed to publish to %s: %w", queue, results[i])
+		}
+	}
+	for i, confirmation := range confirmations {
+		if results[i] != nil {
+			continue
+		}
+		acked, err := confirmation.WaitContext(ctx)
+		switch {
+		case err != nil:
+			results[i] = fmt.Errorf("failed to confirm publish to %s: %w", queue, err)
+		case !acked:
+			results[i] = fmt.Errorf("broker nacked publish to %s", queue)
+		}
+	}
+	return results, nil
+}
+
+// RabbitMQPublishAsync publishes body to queue with RabbitMQPublishConfirmed
+// in the background. The returned channel receives the outcome, nil once
+// the broker confirmed the message, and is then closed.
+func (s *Service) RabbitMQPublishAsync(ctx context.Context, queue string, body []byte) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		defer close(result)
+		result <- s.RabbitMQPublishConfirmed(ctx, queue, body)
+	}()
+	return result
+}
+
+// delayedExchange is the x-delayed-message exchange used for delayed
+// publishes.
+const delayedExchange = "charm.delayed"
+
+// rabbitMQHasDelayedExchange reports whether the broker has the delayed
+// message exchange plugin enabled. It asks the management API, or without
+// one declares the exchange on a throwaway connection: an unknown exchange
+// type is a connection-level COMMAND_INVALID, which would otherwise close
+// the shared connection. A definite answer is cached.
+func (s *Service) rabbitMQHasDelayedExchange(ctx context.Context) (bool, error) {
+	s.mu.Lock()
+	known, available := s.delayedExchangeKnown, s.delayedExchangeAvailable
+	s.mu.Unlock()
+	if known {
+		return available, nil
+	}
+
+	var overview struct {
+		ExchangeTypes []struct {
+			Name string `json:"name"`
+		} `json:"exchange_types"`
+	}
+	err := s.rabbitMQManagementRequest(ctx, http.MethodGet, "/api/overview?columns=exchange_types", nil, &overview)
+	switch {
+	case err == nil:
+		for _, exchangeType := range overview.ExchangeTypes {
+			available = available || exchangeType.Name == "x-delayed-message"
+		}
+	case errors.Is(err, ErrRabbitMQManagementUnavailable):
+		conn, err := s.GetRabbitMQConnectionFromURI()
+		if err != nil {
+			return false, err
+		}
+		defer conn.Close()
+		ch, err := conn.Channel()
+		if err != nil {
+			return false, err
+		}
+		err = ch.ExchangeDeclare(delayedExchange, "x-delayed-message", true, false, false, false,
+			amqp.Table{"x-delayed-type": "direct"})
+		var amqpErr *amqp.Error
+		if err != nil && !(errors.As(err, &amqpErr) && amqpErr.Code == amqp.CommandInvalid) {
+			return false, fmt.Errorf("failed to declare delayed exchange: %w", err)
+		}
+		available = err == nil
+	default:
+		return false, err
+	}
+
+	s.mu.Lock()
+	s.delayedExchangeKnown, s.delayedExchangeAvailable = true, available
+	s.mu.Unlock()
+	return available, nil
+}
+
+// RabbitMQPublishDelayed publishes body to queue through the delayed message
+// exchange plugin so it is delivered after delay. When the plugin is not
+// installed the message is published directly, without delay.
+func (s *Service) RabbitMQPublishDelayed(ctx context.Context, queue string, body []byte, delay time.Duration) error {
+	if err := ValidateQueueName(queue); err != nil {
+		return err
+	}
+	available, err := s.rabbitMQHasDelayedExchange(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to detect the delayed message exchange plugin: %w", err)
+	}
+
+	ch, err := s.Channel()
+	if err != nil {
+		return err
+	}
+	defer s.Release(ch)
+
+	if _, err := ch.QueueDeclare(queue, false, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare queue %s: %w", queue, err)
+	}
+
+	if !available {
+		log.Printf("WARNING: delayed message exchange plugin not available, publishing to %s without delay", queue)
+		return ch.PublishWithContext(ctx, "", queue, false, false, amqp.Publishing{
+			ContentType: "text/plain",
+			Body:        body,
+		})
+	}
+
+	err = ch.ExchangeDeclare(delayedExchange, "x-delayed-message", true, false, false, false,
+		amqp.Table{"x-delayed-type": "direct"})
+	if err != nil {
+		return fmt.Errorf("failed to declare delayed exchange: %w", err)
+	}
+	if err := ch.QueueBind(queue, queue, delayedExchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind queue %s: %w", queue, err)
+	}
+	return ch.PublishWithContext(ctx, delayedExchange, queue, false, false, amqp.Publishing{
+		ContentType: "text/plain",
+		Headers:     amqp.Table{"x-delay": delay.Milliseconds()},
+		Body:        body,
+	})
+}
@@ -0,0 +1,538 @@
+// Copyright 2025 Canonical Ltd.
+// See LICENSE file for licensing details.
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-app/internal/amqputil"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// defaultManagementPort is the port of the RabbitMQ management plugin.
+const defaultManagementPort = 15672
+
+// ErrRabbitMQManagementUnavailable is returned when no management API
+// endpoint could be configured.
+var ErrRabbitMQManagementUnavailable = errors.New("RabbitMQ management API not configured")
+
+// RabbitMQConfig holds the settings used to reach the RabbitMQ management
+// API.
+type RabbitMQConfig struct {
+	ManagementURL string
+	Username      string
+	Password      string
+	VHost         string
+}
+
+// NewRabbitMQConfig derives the management API settings from an AMQP URI:
+// the credentials and vhost are reused and the API is expected on the
+// management port of the first host.
+func NewRabbitMQConfig(amqpURL string) (RabbitMQConfig, error) {
+	if amqpURL == "" {
+		return RabbitMQConfig{}, nil
+	}
+	uris, err := amqputil.ParseMultiHostAMQPURL(amqpURL)
+	if err != nil {
+		return RabbitMQConfig{}, err
+	}
+	uri, err := amqp.ParseURI(uris[0])
+	if err != nil {
+		return RabbitMQConfig{}, err
+	}
+
+	scheme := "http"
+	if uri.Scheme == "amqps" {
+		scheme = "https"
+	}
+	return RabbitMQConfig{
+		ManagementURL: scheme + "://" + net.JoinHostPort(uri.Host, strconv.Itoa(defaultManagementPort)),
+		Username:      uri.Username,
+		Password:      uri.Password,
+		VHost:         uri.Vhost,
+	}, nil
+}
+
+// ManagementAPIError is returned when the management API answers with a
+// non-2xx status code.
+type ManagementAPIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *ManagementAPIError) Error() string {
+	return fmt.Sprintf("management API returned %d: %s", e.StatusCode, e.Body)
+}
+
+// vhostPath returns the URL path segment of the configured vhost.
+func (c RabbitMQConfig) vhostPath() string {
+	vhost := c.VHost
+	if vhost == "" {
+		vhost = "/"
+	}
+	return url.PathEscape(vhost)
+}
+
+// rabbitMQManagementRequest sends a request to the management API, encoding
+// body as JSON when not nil and decoding the response into out when not nil.
+func (s *Service) rabbitMQManagementRequest(ctx context.Context, method, path string, body, out any) error {
+	if s.RabbitMQ.ManagementURL == "" {
+		return ErrRabbitMQManagementUnavailable
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.RabbitMQ.ManagementURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(s.RabbitMQ.Username, s.RabbitMQ.Password)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("management API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &ManagementAPIError{StatusCode: resp.StatusCode, Body: string(data)}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode management API response: %w", err)
+	}
+	return nil
+}
+
+// RabbitMQListQueues returns the names of the queues in the configured vhost.
+func (s *Service) RabbitMQListQueues(ctx context.Context) ([]string, error) {
+	var queues []struct {
+		Name string `json:"name"`
+	}
+	err := s.rabbitMQManagementRequest(ctx, http.MethodGet, "/api/queues/"+s.RabbitMQ.vhostPath()+"?columns=name", nil, &queues)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(queues))
+	for _, queue := range queues {
+		names = append(names, queue.Name)
+	}
+	return names, nil
+}
+
+// BindingInfo describes a binding of a queue to an exchange.
+type BindingInfo struct {
+	Source     string `json:"source"`
+	RoutingKey string `json:"routing_key"`
+}
+
+// RabbitMQListBindings returns the bindings of queue in the configured vhost,
+// including the implicit binding to the default exchange.
+func (s *Service) RabbitMQListBindings(ctx context.Context, queue string) ([]BindingInfo, error) {
+	if err := ValidateQueueName(queue); err != nil {
+		return nil, err
+	}
+
+	bindings := []BindingInfo{}
+	path := "/api/queues/" + s.RabbitMQ.vhostPath() + "/" + url.PathEscape(queue) + "/bindings"
+	if err := s.rabbitMQManagementRequest(ctx, http.MethodGet, path, nil, &bindings); err != nil {
+		return nil, err
+	}
+	return bindings, nil
+}
+
+// RabbitMQConnectionMetrics reports whether the shared connection is open
+// and, when it is, how many channels the broker counts on it. The channel
+// count is looked up by the connection_name the connection advertises.
+func (s *Service) RabbitMQConnectionMetrics(ctx context.Context) (up bool, channels int, err error) {
+	if s.ManagedConnection == nil || s.IsClosed() {
+		return false, 0, nil
+	}
+
+	var connections []struct {
+		UserProvidedName string `json:"user_provided_name"`
+		Channels         int    `json:"channels"`
+	}
+	path := "/api/vhosts/" + s.RabbitMQ.vhostPath() + "/connections?columns=user_provided_name,channels"
+	if err := s.rabbitMQManagementRequest(ctx, http.MethodGet, path, nil, &connections); err != nil {
+		return true, 0, err
+	}
+	for _, connection := range connections {
+		if connection.UserProvidedName == s.Name() {
+			return true, connection.Channels, nil
+		}
+	}
+	return true, 0, fmt.Errorf("connection %q not listed by the management API", s.Name())
+}
+
+// NodeInfo is the health summary of a RabbitMQ cluster node.
+type NodeInfo struct {
+	Name          string `json:"name"`
+	Running       bool   `json:"running"`
+	DiskFreeAlarm bool   `json:"disk_free_alarm"`
+	MemAlarm      bool   `json:"mem_alarm"`
+	FdUsed        int    `json:"fd_used"`
+	SocketsUsed   int    `json:"sockets_used"`
+}
+
+// nodeInfoColumns limits node responses to the NodeInfo fields.
+const nodeInfoColumns = "?columns=name,running,disk_free_alarm,mem_alarm,fd_used,sockets_used"
+
+// RabbitMQGetNodeInfo returns the health summary of the named node, for
+// example rabbit@rabbitmq-0.
+func (s *Service) RabbitMQGetNodeInfo(ctx context.Context, node string) (*NodeInfo, error) {
+	var info NodeInfo
+	if err := s.rabbitMQManagementRequest(ctx, http.MethodGet, "/api/nodes/"+url.PathEscape(node)+nodeInfoColumns, nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// RabbitMQListNodes returns the health summary of every cluster node.
+func (s *Service) RabbitMQListNodes(ctx context.Context) ([]NodeInfo, error) {
+	nodes := []NodeInfo{}
+	if err := s.rabbitMQManagementRequest(ctx, http.MethodGet, "/api/nodes"+nodeInfoColumns, nil, &nodes); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// RabbitMQGetClusterName returns the name of the cluster, as set with
+// rabbitmqctl set_cluster_name.
+func (s *Service) RabbitMQGetClusterName(ctx context.Context) (string, error) {
+	var clusterName struct {
+		Name string `json:"name"`
+	}
+	if err := s.rabbitMQManagementRequest(ctx, http.MethodGet, "/api/cluster-name", nil, &clusterName); err != nil {
+		return "", err
+	}
+	return clusterName.Name, nil
+}
+
+// RabbitMQGetVersion returns the RabbitMQ version of the node serving the
+// management API.
+func (s *Service) RabbitMQGetVersion(ctx context.Context) (string, error) {
+	var overview struct {
+		RabbitMQVersion string `json:"rabbitmq_version"`
+	}
+	if err := s.rabbitMQManagementRequest(ctx, http.MethodGet, "/api/overview?columns=rabbitmq_version", nil, &overview); err != nil {
+		return "", err
+	}
+	return overview.RabbitMQVersion, nil
+}
+
+// MessageRate is the recent publish and deliver throughput of a queue.
+type MessageRate struct {
+	PublishPerSecond float64 `json:"publish_per_second"`
+	DeliverPerSecond float64 `json:"deliver_per_second"`
+}
+
+// RabbitMQMessageRate returns the throughput the management API computed
+// for queue. Rates are zero for a queue that saw no traffic yet.
+func (s *Service) RabbitMQMessageRate(ctx context.Context, queue string) (*MessageRate, error) {
+	if err := ValidateQueueName(queue); err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		MessageStats struct {
+			PublishDetails struct {
+				Rate float64 `json:"rate"`
+			} `json:"publish_details"`
+			DeliverDetails struct {
+				Rate float64 `json:"rate"`
+			} `json:"deliver_details"`
+		} `json:"message_stats"`
+	}
+	path := "/api/queues/" + s.RabbitMQ.vhostPath() + "/" + url.PathEscape(queue) + "?columns=message_stats"
+	if err := s.rabbitMQManagementRequest(ctx, http.MethodGet, path, nil, &response); err != nil {
+		return nil, err
+	}
+	return &MessageRate{
+		PublishPerSecond: response.MessageStats.PublishDetails.Rate,
+		DeliverPerSecond: response.MessageStats.DeliverDetails.Rate,
+	}, nil
+}
+
+// RabbitMQAliveCheck runs the management API aliveness test on the
+// configured vhost: the broker declares a test queue, publishes a message
+// to it, consumes it and reports whether that round trip worked.
+func (s *Service) RabbitMQAliveCheck(ctx context.Context) error {
+	var response struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	}
+	if err := s.rabbitMQManagementRequest(ctx, http.MethodGet, "/api/aliveness-test/"+s.RabbitMQ.vhostPath(), nil, &response); err != nil {
+		return err
+	}
+	if response.Status != "ok" {
+		return fmt.Errorf("aliveness test failed: %s %s", response.Status, response.Reason)
+	}
+	return nil
+}
+
+// AlarmState summarises the resource alarms of the cluster. While an alarm
+// is active the broker blocks publishing connections.
+type AlarmState struct {
+	MemoryAlarm        bool `json:"memory_alarm"`
+	DiskAlarm          bool `json:"disk_alarm"`
+	BlockedConnections int  `json:"blocked_connections"`
+}
+
+// Active reports whether any alarm is raised.
+func (a AlarmState) Active() bool {
+	return a.MemoryAlarm || a.DiskAlarm
+}
+
+// RabbitMQGetAlarmState reports whether any cluster node has a memory or
+// disk alarm raised and how many connections the broker blocked.
+func (s *Service) RabbitMQGetAlarmState(ctx context.Context) (*AlarmState, error) {
+	nodes, err := s.RabbitMQListNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	state := &AlarmState{}
+	for _, node := range nodes {
+		state.MemoryAlarm = state.MemoryAlarm || node.MemAlarm
+		state.DiskAlarm = state.DiskAlarm || node.DiskFreeAlarm
+	}
+
+	var connections []struct {
+		State string `json:"state"`
+	}
+	if err := s.rabbitMQManagementRequest(ctx, http.MethodGet, "/api/connections?columns=state", nil, &connections); err != nil {
+		return nil, err
+	}
+	for _, connection := range connections {
+		if connection.State == "blocked" {
+			state.BlockedConnections++
+		}
+	}
+	return state, nil
+}
+
+// ShovelStatus is the runtime state of a dynamic shovel.
+type ShovelStatus struct {
+	Name                string `json:"name"`
+	State               string `json:"state"`
+	MessagesTransferred int    `json:"messages_transferred"`
+}
+
+// RabbitMQShovelStatus returns the runtime state of the dynamic shovel name
+// in the configured vhost. A shovel that is defined but has no runtime
+// entry, for example while its node restarts, is reported as "not running".
+func (s *Service) RabbitMQShovelStatus(ctx context.Context, name string) (*ShovelStatus, error) {
+	vhost := s.RabbitMQ.vhostPath()
+	// Fails with a 404 ManagementAPIError when no such shovel is defined.
+	if err := s.rabbitMQManagementRequest(ctx, http.MethodGet, "/api/parameters/shovel/"+vhost+"/"+url.PathEscape(name), nil, nil); err != nil {
+		return nil, err
+	}
+
+	var shovels []struct {
+		Name                string `json:"name"`
+		State               string `json:"state"`
+		MessagesTransferred int    `json:"messages_transferred"`
+	}
+	if err := s.rabbitMQManagementRequest(ctx, http.MethodGet, "/api/shovels/"+vhost, nil, &shovels); err != nil {
+		return nil, err
+	}
+	for _, shovel := range shovels {
+		if shovel.Name == name {
+			return &ShovelStatus{Name: name, State: shovel.State, MessagesTransferred: shovel.MessagesTransferred}, nil
+		}
+	}
+	return &ShovelStatus{Name: name, State: "not running"}, nil
+}
+
+// RabbitMQPauseMirrorSync cancels the synchronisation of the mirrors of a
+// classic mirrored queue when pause is true and starts it again otherwise.
+// Classic queue mirroring was removed in RabbitMQ 4.0, where the broker
+// rejects both actions.
+func (s *Service) RabbitMQPauseMirrorSync(ctx context.Context, queue string, pause bool) error {
+	if err := ValidateQueueName(queue); err != nil {
+		return err
+	}
+
+	action := "sync"
+	if pause {
+		action = "cancel_sync"
+	}
+	path := "/api/queues/" + s.RabbitMQ.vhostPath() + "/" + url.PathEscape(queue) + "/actions"
+	return s.rabbitMQManagementRequest(ctx, http.MethodPost, path, map[string]string{"action": action}, nil)
+}
+
+// VhostPermissions are the regular expressions of the resource names a user
+// may configure, write to and read from in a vhost.
+type VhostPermissions struct {
+	Configure string `json:"configure"`
+	Write     string `json:"write"`
+	Read      string `json:"read"`
+}
+
+// RabbitMQGetVhostPermissions returns the permissions of user on the
+// configured vhost. The management API answers 404, as a
+// *ManagementAPIError, when the user has no permissions on it.
+func (s *Service) RabbitMQGetVhostPermissions(ctx context.Context, user string) (*VhostPermissions, error) {
+	var permissions VhostPermissions
+	path := "/api/permissions/" + s.RabbitMQ.vhostPath() + "/" + url.PathEscape(user)
+	if err := s.rabbitMQManagementRequest(ctx, http.MethodGet, path, nil, &permissions); err != nil {
+		return nil, err
+	}
+	return &permissions, nil
+}
+
+// ConsumerInfo describes a consumer attached to a queue.
+type ConsumerInfo struct {
+	ConsumerTag string `json:"consumer_tag"`
+	Queue       string `json:"queue"`
+	Exclusive   bool   `json:"exclusive"`
+	AckRequired bool   `json:"ack_required"`
+}
+
+// RabbitMQListConsumers lists the consumers of every queue of the configured
+// vhost.
+func (s *Service) RabbitMQListConsumers(ctx context.Context) ([]ConsumerInfo, error) {
+	var consumers []struct {
+		ConsumerTag string `json:"consumer_tag"`
+		Queue       struct {
+			Name string `json:"name"`
+		} `json:"queue"`
+		Exclusive   bool `json:"exclusive"`
+		AckRequired bool `json:"ack_required"`
+	}
+	if err := s.rabbitMQManagementRequest(ctx, http.MethodGet, "/api/consumers/"+s.RabbitMQ.vhostPath(), nil, &consumers); err != nil {
+		return nil, err
+	}
+
+	infos := make([]ConsumerInfo, 0, len(consumers))
+	for _, consumer := range consumers {
+		infos = append(infos, ConsumerInfo{
+			ConsumerTag: consumer.ConsumerTag,
+			Queue:       consumer.Queue.Name,
+			Exclusive:   consumer.Exclusive,
+			AckRequired: consumer.AckRequired,
+		})
+	}
+	return infos, nil
+}
+
+// ConsumerGroup is a set of consumers sharing a quorum queue, identified by
+// the {group}-{instance} consumer tag convention.
+type ConsumerGroup struct {
+	Members  int    `json:"members"`
+	Queue    string `json:"queue"`
+	Messages int    `json:"messages"`
+}
+
+// RabbitMQConsumerGroups groups the consumers of the quorum queues of the
+// configured vhost by the part of their consumer tag before the last "-".
+// Broker generated tags and tags without "-" are not part of a group. A
+// group consuming from several queues is reported once per queue, keyed as
+// {group}@{queue}.
+func (s *Service) RabbitMQConsumerGroups(ctx context.Context) (map[string]ConsumerGroup, error) {
+	var queues []struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}
+	err := s.rabbitMQManagementRequest(ctx, http.MethodGet, "/api/queues/"+s.RabbitMQ.vhostPath()+"?columns=name,type", nil, &queues)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := map[string]ConsumerGroup{}
+	for _, queue := range queues {
+		if queue.Type != "quorum" {
+			continue
+		}
+		var details struct {
+			Messages        int `json:"messages"`
+			ConsumerDetails []struct {
+				ConsumerTag string `json:"consumer_tag"`
+			} `json:"consumer_details"`
+		}
+		path := "/api/queues/" + s.RabbitMQ.vhostPath() + "/" + url.PathEscape(queue.Name)
+		if err := s.rabbitMQManagementRequest(ctx, http.MethodGet, path, nil, &details); err != nil {
+			return nil, err
+		}
+
+		for _, consumer := range details.ConsumerDetails {
+			i := strings.LastIndex(consumer.ConsumerTag, "-")
+			if i <= 0 || strings.HasPrefix(consumer.ConsumerTag, "amq.ctag-") {
+				continue
+			}
+			name := consumer.ConsumerTag[:i]
+			if group, ok := groups[name]; ok && group.Queue != queue.Name {
+				name += "@" + queue.Name
+			}
+			group := groups[name]
+			group.Members++
+			group.Queue = queue.Name
+			group.Messages = details.Messages
+			groups[name] = group
+		}
+	}
+	return groups, nil
+}
+
+// DefaultDLQSuffixes are the name fragments that mark a dead-letter queue
+// when RabbitMQDLQSuffixes is empty.
+var DefaultDLQSuffixes = []string{"_dlq", "_dead_letter"}
+
+// DLQStat is the depth of a dead-letter queue.
+type DLQStat struct {
+	Queue    string `json:"queue"`
+	Messages int    `json:"messages"`
+}
+
+// RabbitMQDeadLetterStats returns the message count of the queues of the
+// configured vhost whose name contains one of RabbitMQDLQSuffixes.
+func (s *Service) RabbitMQDeadLetterStats(ctx context.Context) ([]DLQStat, error) {
+	suffixes := s.RabbitMQDLQSuffixes
+	if len(suffixes) == 0 {
+		suffixes = DefaultDLQSuffixes
+	}
+
+	var queues []struct {
+		Name     string `json:"name"`
+		Messages int    `json:"messages"`
+	}
+	err := s.rabbitMQManagementRequest(ctx, http.MethodGet, "/api/queues/"+s.RabbitMQ.vhostPath()+"?columns=name,messages", nil, &queues)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := []DLQStat{}
+	for _, queue := range queues {
+		for _, suffix := range suffixes {
+			if strings.Contains(queue.Name, suffix) {
+				stats = append(stats, DLQStat{Queue: queue.Name, Messages: queue.Messages})
+				break
+			}
+		}
+	}
+	return stats, nil
+}
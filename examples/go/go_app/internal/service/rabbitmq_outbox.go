@@ -0,0 +1,144 @@
+// Copyright 2025 Canonical Ltd.
+// See LICENSE file for licensing details.
+
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	// outboxBatchSize is the number of outbox rows relayed per poll.
+	outboxBatchSize = 100
+	// outboxMaxAttempts is the number of failed publishes after which a
+	// row is marked as failed and no longer relayed.
+	outboxMaxAttempts = 10
+	// outboxLease is how long a claimed row is hidden from other relays
+	// while it is published.
+	outboxLease = time.Minute
+	// outboxMaxBackoff caps the exponential delay between the attempts of
+	// a row.
+	outboxMaxBackoff = 5 * time.Minute
+)
+
+// PostgresqlCreateOutbox creates the outbox table used by
+// RabbitMQPublishScheduled if it does not exist, and adds the retry columns
+// to a table created before they existed.
+func (s *Service) PostgresqlCreateOutbox(ctx context.Context) error {
+	err := s.PostgresqlExecuteTransaction(ctx, []string{`
+		CREATE TABLE IF NOT EXISTS outbox (
+			id bigserial PRIMARY KEY,
+			queue text NOT NULL,
+			body bytea NOT NULL,
+			created_at timestamptz NOT NULL DEFAULT now(),
+			sent_at timestamptz
+		)`,
+		"ALTER TABLE outbox ADD COLUMN IF NOT EXISTS attempts integer NOT NULL DEFAULT 0",
+		"ALTER TABLE outbox ADD COLUMN IF NOT EXISTS last_error text",
+		"ALTER TABLE outbox ADD COLUMN IF NOT EXISTS next_attempt_at timestamptz NOT NULL DEFAULT now()",
+		"ALTER TABLE outbox ADD COLUMN IF NOT EXISTS failed_at timestamptz",
+		`CREATE INDEX IF NOT EXISTS outbox_pending ON outbox (next_attempt_at, id)
+			WHERE sent_at IS NULL AND failed_at IS NULL`,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create outbox table: %w", err)
+	}
+	return nil
+}
+
+// RabbitMQPublishScheduled records body for queue in the outbox as part of
+// tx. The message is published by RabbitMQRelayOutbox once tx commits, so it
+// is never lost nor sent for a rolled back transaction.
+func (s *Service) RabbitMQPublishScheduled(ctx context.Context, tx *sql.Tx, queue string, body []byte) error {
+	if err := ValidateQueueName(queue); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO outbox (queue, body) VALUES ($1, $2)", queue, body); err != nil {
+		return fmt.Errorf("failed to schedule message for %s: %w", queue, err)
+	}
+	return nil
+}
+
+// RabbitMQRelayOutbox publishes a batch of the outbox messages due for an
+// attempt and returns how many were sent. The batch is claimed for
+// outboxLease in a short transaction, so several units can relay the same
+// outbox without holding row locks across the broker round trips. A failed
+// publish is retried with exponential backoff and the row is marked failed
+// after outboxMaxAttempts, so it no longer holds back newer messages.
+// Delivery is at least once: a message whose sent_at update is lost is
+// published again once its lease expires.
+func (s *Service) RabbitMQRelayOutbox(ctx context.Context) (int, error) {
+	db, err := s.postgresqlDB()
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		UPDATE outbox SET next_attempt_at = now() + $2 * interval '1 ms'
+		WHERE id IN (
+			SELECT id
+			FROM outbox
+			WHERE sent_at IS NULL AND failed_at IS NULL AND next_attempt_at <= now()
+			ORDER BY next_attempt_at, id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING id, queue, body`, outboxBatchSize, outboxLease.Milliseconds())
+	if err != nil {
+		return 0, err
+	}
+	type message struct {
+		id    int64
+		queue string
+		body  []byte
+	}
+	var messages []message
+	for rows.Next() {
+		var msg message
+		if err := rows.Scan(&msg.id, &msg.queue, &msg.body); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		messages = append(messages, msg)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, msg := range messages {
+		if err := s.RabbitMQPublishConfirmed(ctx, msg.queue, msg.body); err != nil {
+			log.Printf("Outbox message %d not published: %v", msg.id, err)
+			if err := s.recordOutboxFailure(ctx, db, msg.id, err); err != nil {
+				return sent, err
+			}
+			continue
+		}
+		if _, err := db.ExecContext(ctx, "UPDATE outbox SET sent_at = now() WHERE id = $1", msg.id); err != nil {
+			return sent, err
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// recordOutboxFailure counts a failed publish of the outbox row id and
+// schedules its next attempt, or marks it failed after outboxMaxAttempts.
+func (s *Service) recordOutboxFailure(ctx context.Context, db *sql.DB, id int64, publishErr error) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE outbox SET
+			attempts = attempts + 1,
+			last_error = $2,
+			next_attempt_at = now() + least(power(2, attempts) * interval '1 second', $3 * interval '1 ms'),
+			failed_at = CASE WHEN attempts + 1 >= $4 THEN now() END
+		WHERE id = $1`, id, publishErr.Error(), outboxMaxBackoff.Milliseconds(), outboxMaxAttempts)
+	if err != nil {
+		return fmt.Errorf("failed to record outbox failure of %d: %w", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,327 @@
+// Copyright 2025 Canonical Ltd.
+// See LICENSE file for licensing details.
+
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	streamamqp "github.com/rabbitmq/rabbitmq-stream-go-client/pkg/amqp"
+	"github.com/rabbitmq/rabbitmq-stream-go-client/pkg/message"
+	"github.com/rabbitmq/rabbitmq-stream-go-client/pkg/stream"
+)
+
+// rabbitMQStreamEnvironment returns the cached RabbitMQ Streams environment,
+// creating it on first use.
+func (s *Service) rabbitMQStreamEnvironment() (*stream.Environment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.streamEnv != nil && !s.streamEnv.IsClosed() {
+		return s.streamEnv, nil
+	}
+	if s.RabbitMQStreamHost == "" {
+		return nil, fmt.Errorf("RABBITMQ_STREAM_HOST not set")
+	}
+
+	env, err := stream.NewEnvironment(
+		stream.NewEnvironmentOptions().
+			SetHost(s.RabbitMQStreamHost).
+			SetPort(s.RabbitMQStreamPort).
+			SetUser(s.RabbitMQStreamUser).
+			SetPassword(s.RabbitMQStreamPassword),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stream environment: %w", err)
+	}
+	s.streamEnv = env
+	// Producers of a previous environment were closed with it.
+	s.streamProducers = nil
+	return env, nil
+}
+
+// streamProducer is a producer shared by the publishes to one stream. mu
+// serializes them, so each publish reads its own confirmation.
+type streamProducer struct {
+	mu       sync.Mutex
+	producer *stream.Producer
+	confirms stream.ChannelPublishConfirm
+}
+
+// rabbitMQStreamProducer returns the cached producer of streamName,
+// declaring the stream and creating the producer on first use.
+func (s *Service) rabbitMQStreamProducer(streamName string) (*streamProducer, error) {
+	env, err := s.rabbitMQStreamEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	p, ok := s.streamProducers[streamName]
+	s.mu.Unlock()
+	if ok {
+		return p, nil
+	}
+
+	if err := env.DeclareStream(streamName, &stream.StreamOptions{}); err != nil {
+		return nil, fmt.Errorf("failed to declare stream %s: %w", streamName, err)
+	}
+	producer, err := env.NewProducer(streamName, stream.NewProducerOptions())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stream producer: %w", err)
+	}
+	p = &streamProducer{producer: producer, confirms: producer.NotifyPublishConfirmation()}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cached, ok := s.streamProducers[streamName]; ok {
+		// Another publish created one first.
+		producer.Close()
+		return cached, nil
+	}
+	if s.streamProducers == nil {
+		s.streamProducers = make(map[string]*streamProducer)
+	}
+	s.streamProducers[streamName] = p
+	return p, nil
+}
+
+// dropStreamProducer closes p and removes it from the cache, so the next
+// publish to streamName creates a new producer.
+func (s *Service) dropStreamProducer(streamName string, p *streamProducer) {
+	s.mu.Lock()
+	if s.streamProducers[streamName] == p {
+		delete(s.streamProducers, streamName)
+	}
+	s.mu.Unlock()
+	p.producer.Close()
+}
+
+// RabbitMQStreamPublish publishes a single message to the given stream,
+// declaring the stream if needed, and waits for the broker confirmation.
+// The producer of each stream is kept for later publishes.
+func (s *Service) RabbitMQStreamPublish(ctx context.Context, streamName string, body []byte) error {
+	if err := ValidateQueueName(streamName); err != nil {
+		return err
+	}
+
+	p, err := s.rabbitMQStreamProducer(streamName)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var msg message.StreamMessage = streamamqp.NewMessage(body)
+	if err := p.producer.Send(msg); err != nil {
+		s.dropStreamProducer(streamName, p)
+		return fmt.Errorf("failed to send stream message: %w", err)
+	}
+
+	for {
+		select {
+		case statuses, ok := <-p.confirms:
+			if !ok {
+				s.dropStreamProducer(streamName, p)
+				return fmt.Errorf("stream producer closed before confirmation")
+			}
+			for _, status := range statuses {
+				// Skip confirmations left over from a publish whose
+				// context ended first.
+				if status.GetMessage() != msg {
+					continue
+				}
+				if !status.IsConfirmed() {
+					return fmt.Errorf("stream message not confirmed: %v", status.GetError())
+				}
+				return nil
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// StreamOffset is where RabbitMQStreamConsume starts reading a stream.
+type StreamOffset struct {
+	spec   string
+	offset int64
+}
+
+var (
+	// StreamOffsetFirst starts at the first message still in the stream.
+	StreamOffsetFirst = StreamOffset{spec: "first"}
+	// StreamOffsetLast starts at the last chunk written to the stream.
+	StreamOffsetLast = StreamOffset{spec: "last"}
+	// StreamOffsetNext only reads messages written after subscribing.
+	StreamOffsetNext = StreamOffset{spec: "next"}
+	// StreamOffsetStored resumes after the offset stored for the consumer,
+	// or starts at the first message when none is stored.
+	StreamOffsetStored = StreamOffset{spec: "stored"}
+)
+
+// StreamOffsetAt starts at the message with the given offset.
+func StreamOffsetAt(offset int64) StreamOffset {
+	return StreamOffset{offset: offset}
+}
+
+// ParseStreamOffset parses "first", "last", "next", "stored" or a
+// non-negative numeric offset.
+func ParseStreamOffset(str string) (StreamOffset, error) {
+	switch str {
+	case "first":
+		return StreamOffsetFirst, nil
+	case "last":
+		return StreamOffsetLast, nil
+	case "next":
+		return StreamOffsetNext, nil
+	case "stored":
+		return StreamOffsetStored, nil
+	}
+	offset, err := strconv.ParseInt(str, 10, 64)
+	if err != nil || offset < 0 {
+		return StreamOffset{}, fmt.Errorf("invalid stream offset %q", str)
+	}
+	return StreamOffsetAt(offset), nil
+}
+
+func (o StreamOffset) specification() stream.OffsetSpecification {
+	switch o.spec {
+	case "first":
+		return stream.OffsetSpecification{}.First()
+	case "last":
+		return stream.OffsetSpecification{}.Last()
+	case "next":
+		return stream.OffsetSpecification{}.Next()
+	}
+	return stream.OffsetSpecification{}.Offset(o.offset)
+}
+
+// RabbitMQStreamConsume reads streamName from offset and calls handler for
+// each message until ctx is done, handler fails or the consumer is closed.
+// With a consumerName, the offset of the last message handled successfully
+// is stored on the broker once consumption ends, and StreamOffsetStored
+// resumes after it.
+func (s *Service) RabbitMQStreamConsume(ctx context.Context, streamName, consumerName string, offset StreamOffset, handler func([]byte) error) error {
+	if err := ValidateQueueName(streamName); err != nil {
+		return err
+	}
+	env, err := s.rabbitMQStreamEnvironment()
+	if err != nil {
+		return err
+	}
+
+	spec := offset.specification()
+	if offset == StreamOffsetStored {
+		spec = stream.OffsetSpecification{}.First()
+		if consumerName != "" {
+			stored, err := env.QueryOffset(consumerName, streamName)
+			switch {
+			case err == nil:
+				spec = stream.OffsetSpecification{}.Offset(stored + 1)
+			case !errors.Is(err, stream.OffsetNotFoundError):
+				return fmt.Errorf("failed to query offset of %s on %s: %w", consumerName, streamName, err)
+			}
+		}
+	}
+
+	options := stream.NewConsumerOptions().SetOffset(spec)
+	if consumerName != "" {
+		options = options.SetConsumerName(consumerName).SetManualCommit()
+	}
+	failed := make(chan error, 1)
+	// mu is held for each whole message, so once consumption ends the
+	// message in flight, if any, is finished and counted in handled.
+	var mu sync.Mutex
+	stopped := false
+	handled := int64(-1)
+	consumer, err := env.NewConsumer(streamName, func(consumerContext stream.ConsumerContext, message *streamamqp.Message) {
+		mu.Lock()
+		defer mu.Unlock()
+		// Messages already sent by the broker keep coming until Close.
+		if stopped || ctx.Err() != nil {
+			return
+		}
+		if err := handler(message.GetData()); err != nil {
+			stopped = true
+			failed <- err
+			return
+		}
+		handled = consumerContext.Consumer.GetOffset()
+	}, options)
+	if err != nil {
+		return fmt.Errorf("failed to create stream consumer: %w", err)
+	}
+	defer consumer.Close()
+
+	select {
+	case err = <-failed:
+	case event := <-consumer.NotifyClose():
+		err = fmt.Errorf("stream consumer closed: %v", event.Err)
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	mu.Lock()
+	stopped = true
+	last := handled
+	mu.Unlock()
+
+	if consumerName != "" && last >= 0 {
+		if storeErr := env.StoreOffset(consumerName, streamName, last); storeErr != nil {
+			storeErr = fmt.Errorf("failed to store offset of %s on %s: %w", consumerName, streamName, storeErr)
+			if ctx.Err() != nil {
+				// Ending on ctx is expected; the lost offset is not.
+				return storeErr
+			}
+			return errors.Join(err, storeErr)
+		}
+	}
+	return err
+}
+
+// ErrStreamOffsetNotFound is returned when no offset is stored for a
+// consumer of a stream.
+var ErrStreamOffsetNotFound = errors.New("stream offset not found")
+
+// RabbitMQStreamStoreOffset stores offset on the broker as the checkpoint of
+// consumerName on streamName.
+func (s *Service) RabbitMQStreamStoreOffset(ctx context.Context, streamName, consumerName string, offset int64) error {
+	env, err := s.rabbitMQStreamEnvironment()
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := env.StoreOffset(consumerName, streamName, offset); err != nil {
+		return fmt.Errorf("failed to store offset of %s on %s: %w", consumerName, streamName, err)
+	}
+	return nil
+}
+
+// RabbitMQStreamQueryOffset returns the checkpoint stored for consumerName on
+// streamName, or ErrStreamOffsetNotFound if it has none.
+func (s *Service) RabbitMQStreamQueryOffset(ctx context.Context, streamName, consumerName string) (int64, error) {
+	env, err := s.rabbitMQStreamEnvironment()
+	if err != nil {
+		return 0, err
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	offset, err := env.QueryOffset(consumerName, streamName)
+	if errors.Is(err, stream.OffsetNotFoundError) {
+		return 0, fmt.Errorf("%w: %s on %s", ErrStreamOffsetNotFound, consumerName, streamName)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to query offset of %s on %s: %w", consumerName, streamName, err)
+	}
+	return offset, nil
+}
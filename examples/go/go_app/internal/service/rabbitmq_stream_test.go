@@ -0,0 +1,39 @@
+// Copyright 2025 Canonical Ltd.
+// See LICENSE file for licensing details.
+
+package service
+
+import (
+	"testing"
+)
+
+func TestParseStreamOffset(t *testing.T) {
+	tests := []struct {
+		str     string
+		want    StreamOffset
+		wantErr bool
+	}{
+		{str: "first", want: StreamOffsetFirst},
+		{str: "last", want: StreamOffsetLast},
+		{str: "next", want: StreamOffsetNext},
+		{str: "stored", want: StreamOffsetStored},
+		{str: "0", want: StreamOffsetAt(0)},
+		{str: "42", want: StreamOffsetAt(42)},
+		{str: "-1", wantErr: true},
+		{str: "", wantErr: true},
+		{str: "First", wantErr: true},
+		{str: "1.5", wantErr: true},
+		{str: "99999999999999999999", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.str, func(t *testing.T) {
+			got, err := ParseStreamOffset(tt.str)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseStreamOffset(%q) error = %v, want error %v", tt.str, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseStreamOffset(%q) = %+v, want %+v", tt.str, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2025 Canonical Ltd.
+// See LICENSE file for licensing details.
+
+package service
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fakeQueueDeclarer records the arguments of its QueueDeclare calls.
+type fakeQueueDeclarer struct {
+	err   error
+	calls []fakeQueueDeclare
+}
+
+type fakeQueueDeclare struct {
+	name                                   string
+	durable, autoDelete, exclusive, noWait bool
+	args                                   amqp.Table
+}
+
+func (f *fakeQueueDeclarer) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	f.calls = append(f.calls, fakeQueueDeclare{name, durable, autoDelete, exclusive, noWait, args})
+	return amqp.Queue{Name: name}, f.err
+}
+
+func TestDeclareExclusiveQueue(t *testing.T) {
+	want := fakeQueueDeclare{
+		name:    "orders",
+		durable: true,
+		args: amqp.Table{
+			"x-queue-type":             "quorum",
+			"x-single-active-consumer": true,
+		},
+	}
+	brokerErr := errors.New("connection reset")
+
+	tests := []struct {
+		name    string
+		err     error
+		wantErr error
+	}{
+		{name: "declared"},
+		{name: "existing queue with other settings", err: &amqp.Error{Code: amqp.PreconditionFailed, Reason: "inequivalent arg"}, wantErr: ErrQueueConflict},
+		{name: "other error", err: brokerErr, wantErr: brokerErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := &fakeQueueDeclarer{err: tt.err}
+			err := declareExclusiveQueue(ch, "orders")
+			if (tt.wantErr == nil && err != nil) || !errors.Is(err, tt.wantErr) {
+				t.Fatalf("declareExclusiveQueue() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(ch.calls) != 1 {
+				t.Fatalf("QueueDeclare called %d times, want 1", len(ch.calls))
+			}
+			if !reflect.DeepEqual(ch.calls[0], want) {
+				t.Errorf("QueueDeclare(%+v), want %+v", ch.calls[0], want)
+			}
+		})
+	}
+}
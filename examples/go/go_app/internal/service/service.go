@@ -6,11 +6,17 @@ package service
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"go-app/internal/amqputil"
 	"log"
+	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rabbitmq/rabbitmq-stream-go-client/pkg/stream"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/trace"
@@ -31,10 +37,110 @@ func SubOperation(ctx context.Context) error {
 	return nil
 }
 
+// ErrInvalidName is returned when a user supplied queue, exchange or SQL
+// identifier does not pass validation.
+var ErrInvalidName = errors.New("invalid name")
+
 type Service struct {
+	// ManagedConnection is the shared RabbitMQ connection used by the
+	// RabbitMQ methods that do not target a specific unit.
+	*amqputil.ManagedConnection
+
 	PostgresqlURL string
-	RabbitMQURL   string
-	RabbitMQURLS  []string
+	// PostgresqlStatementTimeout is applied to the sessions of the shared
+	// pool, not to the maintenance pool used for DDL, VACUUM and ANALYZE; zero
+	// leaves the server setting.
+	PostgresqlStatementTimeout time.Duration
+
+	RabbitMQURL  string
+	RabbitMQURLS []string
+	RabbitMQ     RabbitMQConfig
+	// RabbitMQDLQSuffixes are the name fragments of dead-letter queues,
+	// DefaultDLQSuffixes when empty.
+	RabbitMQDLQSuffixes []string
+
+	RabbitMQStreamHost     string
+	RabbitMQStreamPort     int
+	RabbitMQStreamUser     string
+	RabbitMQStreamPassword string
+
+	FGAAPIURL  string
+	FGAStoreID string
+	FGAToken   string
+	// FGARetryMaxAttempts caps the attempts per OpenFGA request, including
+	// the first one. Zero keeps the SDK default.
+	FGARetryMaxAttempts int
+	// FGAOAuth2TokenURL enables exchanging FGAToken for an OpenFGA token
+	// with FGAClientID and FGAClientSecret, for FGAAudience.
+	FGAOAuth2TokenURL string
+	FGAClientID       string
+	FGAClientSecret   string
+	FGAAudience       string
+
+	// HTTPClient sends the RabbitMQ management API and OpenFGA token
+	// requests, defaultHTTPClient when nil.
+	HTTPClient *http.Client
+
+	mu            sync.Mutex
+	db            *sql.DB
+	maintenanceDB *sql.DB
+	streamEnv     *stream.Environment
+	// streamProducers caches the producer of each stream of streamEnv.
+	streamProducers map[string]*streamProducer
+	// priorityConsumers are the running priority consumers by tag.
+	priorityConsumers map[string]*priorityConsumer
+	// delayedExchangeKnown is set once delayedExchangeAvailable caches
+	// the result of rabbitMQHasDelayedExchange.
+	delayedExchangeKnown     bool
+	delayedExchangeAvailable bool
+
+	fgaTokenMu     sync.Mutex
+	fgaToken       string
+	fgaTokenExpiry time.Time
+}
+
+// defaultHTTPClient bounds the HTTP requests of a Service without an
+// HTTPClient, so an unresponsive server cannot hang a handler.
+var defaultHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+func (s *Service) httpClient() *http.Client {
+	if s.HTTPClient != nil {
+		return s.HTTPClient
+	}
+	return defaultHTTPClient
+}
+
+// Close releases the long-lived connections and clients held by the Service.
+func (s *Service) Close() error {
+	var errs []error
+	s.mu.Lock()
+	for _, pc := range s.priorityConsumers {
+		pc.cancel()
+	}
+	s.mu.Unlock()
+	if s.ManagedConnection != nil {
+		errs = append(errs, s.ManagedConnection.Close())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db != nil {
+		errs = append(errs, s.db.Close())
+		s.db = nil
+	}
+	if s.maintenanceDB != nil {
+		errs = append(errs, s.maintenanceDB.Close())
+		s.maintenanceDB = nil
+	}
+	for _, p := range s.streamProducers {
+		errs = append(errs, p.producer.Close())
+	}
+	s.streamProducers = nil
+	if s.streamEnv != nil {
+		errs = append(errs, s.streamEnv.Close())
+		s.streamEnv = nil
+	}
+	return errors.Join(errs...)
 }
 
 func (s *Service) CheckPostgresqlMigrateStatus() (err error) {
@@ -70,15 +176,39 @@ func (s *Service) GetRabbitMQConnectionFromURI() (*amqp.Connection, error) {
 	return amqp.Dial(s.RabbitMQURL)
 }
 
+// rabbitMQURIs returns the per-unit URIs from RABBITMQ_CONNECT_STRINGS,
+// falling back to the hosts listed in RABBITMQ_CONNECT_STRING.
+func (s *Service) rabbitMQURIs() ([]string, error) {
+	var uris []string
+	for _, uri := range s.RabbitMQURLS {
+		if uri = strings.TrimSpace(uri); uri != "" {
+			uris = append(uris, uri)
+		}
+	}
+	if len(uris) > 0 {
+		return uris, nil
+	}
+	if s.RabbitMQURL == "" {
+		return nil, fmt.Errorf("no uris available in RABBITMQ_CONNECT_STRINGS or RABBITMQ_CONNECT_STRING")
+	}
+	return amqputil.ParseMultiHostAMQPURL(s.RabbitMQURL)
+}
+
 // GetRabbitMQConnection handles multiple unit addresses by parsing hostnames
 func (s *Service) GetRabbitMQConnection() (*amqp.Connection, error) {
-	if len(s.RabbitMQURLS) == 0 {
-		return nil, fmt.Errorf("no uris available in RABBITMQ_CONNECT_STRINGS")
+	return s.DialRabbitMQ(amqp.Config{Locale: "en_US"})
+}
+
+// DialRabbitMQ is GetRabbitMQConnection with a custom connection config.
+func (s *Service) DialRabbitMQ(config amqp.Config) (*amqp.Connection, error) {
+	uris, err := s.rabbitMQURIs()
+	if err != nil {
+		return nil, err
 	}
 
-	for _, ip := range s.RabbitMQURLS {
+	for _, ip := range uris {
 		log.Printf("Attempting to connect to unit: %s", ip)
-		conn, err := amqp.Dial(ip)
+		conn, err := amqp.DialConfig(ip, config)
 		if err == nil {
 			log.Printf("Successfully connected to unit: %s", ip)
 			return conn, nil
@@ -91,17 +221,11 @@ func (s *Service) GetRabbitMQConnection() (*amqp.Connection, error) {
 
 // CheckRabbitMQStatus connects to RabbitMQ and declares a test queue
 func (s *Service) CheckRabbitMQStatus() error {
-	conn, err := s.GetRabbitMQConnection()
-	if err != nil {
-		return fmt.Errorf("failed to connect to RabbitMQ: %w", err)
-	}
-	defer conn.Close()
-
-	ch, err := conn.Channel()
+	ch, err := s.Channel()
 	if err != nil {
 		return fmt.Errorf("failed to open a channel: %w", err)
 	}
-	defer ch.Close()
+	defer s.Release(ch)
 
 	_, err = ch.QueueDeclare(
 		"test_queue", // name
@@ -119,17 +243,11 @@ func (s *Service) CheckRabbitMQStatus() error {
 	return nil
 }
 func (s *Service) RabbitMQSend() error {
-	conn, err := s.GetRabbitMQConnection()
+	ch, err := s.Channel()
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
-
-	ch, err := conn.Channel()
-	if err != nil {
-		return err
-	}
-	defer ch.Close()
+	defer s.Release(ch)
 
 	// Declare a classic, non-durable queue to match existing deployments
 	q, err := ch.QueueDeclare(
@@ -196,17 +314,11 @@ func (s *Service) RabbitMQSendToUnit(unitIndex int) error {
 }
 
 func (s *Service) RabbitMQReceive() (string, error) {
-	conn, err := s.GetRabbitMQConnection()
+	ch, err := s.Channel()
 	if err != nil {
 		return "FAIL. NO CONNECTION.", err
 	}
-	defer conn.Close()
-
-	ch, err := conn.Channel()
-	if err != nil {
-		return "FAIL", err
-	}
-	defer ch.Close()
+	defer s.Release(ch)
 
 	// basic_get in RabbitMQ (non-streaming)
 	msg, ok, err := ch.Get("charm", false)
@@ -222,6 +334,8 @@ func (s *Service) RabbitMQReceive() (string, error) {
 		return "SUCCESS", nil
 	}
 
+	// Requeue it explicitly, the channel goes back to the pool.
+	msg.Nack(false, true)
 	return "FAIL. INCORRECT MESSAGE.", nil
 }
 
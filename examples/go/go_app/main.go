@@ -5,13 +5,21 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/subtle"
 	"crypto/tls"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"go-app/internal/amqputil"
 	"go-app/internal/service"
 	"io"
 	"log"
+	"math"
+	"mime"
 	"net/http"
 	"net/mail"
 	"net/smtp"
@@ -20,21 +28,25 @@ import (
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	amqp "github.com/rabbitmq/amqp091-go"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/trace"
 
-	. "github.com/openfga/go-sdk/client"
-	"github.com/openfga/go-sdk/credentials"
+	openfga "github.com/openfga/go-sdk"
+	"golang.org/x/time/rate"
 
 	"github.com/gorilla/sessions"
 	"github.com/markbates/goth"
@@ -44,10 +56,19 @@ import (
 
 // OIDC-specific constants for the session store
 const (
-	maxAge      = 86400 * 30 // 30 days
+	maxAge      = 86400 * 30 // 30 days, unless APP_OIDC_MAX_SESSION_AGE is set
 	SessionName = "_user_session"
 )
 
+// maxOIDCClockSkew bounds APP_OIDC_CLOCK_SKEW_SECONDS; a wider window
+// would accept tokens that are meaningfully expired.
+const maxOIDCClockSkew = 300 * time.Second
+
+// gothOIDCExpirySkew is the fixed skew goth's openidConnect provider allows
+// on exp before ValidateIDToken runs, so a larger OIDC clock skew cannot
+// extend the lifetime of a token, only the tolerance on iat and nbf.
+const gothOIDCExpirySkew = 10 * time.Second
+
 // Config holds all application configuration, read once from the environment.
 type Config struct {
 	BaseURL     string
@@ -57,18 +78,67 @@ type Config struct {
 	Port        string
 	MetricsPort string
 	MetricsPath string
+
+	EnableDebugEndpoints bool
+	FGAPollInterval      time.Duration
+	OIDCClockSkew        time.Duration
+	OIDCMaxSessionAge    time.Duration
+	FGACountRateLimit    rate.Limit
+	FGARetryMaxAttempts  int
+
+	DBWaitTimeout             time.Duration
+	DBStatementTimeout        time.Duration
+	PostgresqlMetricsInterval time.Duration
+	DBMetricsInterval         time.Duration
+	// DebugQueryMaxCost is the planner cost above which /postgresql/query
+	// refuses a query; zero disables the check.
+	DebugQueryMaxCost float64
+
+	RabbitMQStreamPort      int
+	RabbitMQChannelPoolSize int
+	RabbitMQMetricsInterval time.Duration
+	OutboxPollInterval      time.Duration
+	// RabbitMQNackAllMax caps the messages /rabbitmq/nack-all holds unacked
+	// at once.
+	RabbitMQNackAllMax int
+
+	// RabbitMQDLQSuffixes and RabbitMQDLQAlertThreshold select the
+	// dead-letter queues whose depth is reported, and the depth above
+	// which a warning is logged; zero disables the warning.
+	RabbitMQDLQSuffixes       []string
+	RabbitMQDLQAlertThreshold int
+}
+
+// envInt returns the integer value of the environment variable name, or def
+// when it is not set. Values rejected by valid are reported as errors.
+func envInt(name string, def int, valid func(int) bool) (int, error) {
+	str, found := os.LookupEnv(name)
+	if !found {
+		return def, nil
+	}
+	value, err := strconv.Atoi(str)
+	if err != nil || !valid(value) {
+		return def, fmt.Errorf("invalid %s: %q", name, str)
+	}
+	return value, nil
 }
 
-// NewConfig creates a new Config struct from environment variables.
+func positive(v int) bool    { return v > 0 }
+func nonNegative(v int) bool { return v >= 0 }
+
+// NewConfig creates a new Config struct from environment variables. All
+// invalid variables are reported, joined in the returned error.
 func NewConfig() (Config, error) {
+	var errs []error
 	baseURLStr := os.Getenv("APP_BASE_URL")
 	if baseURLStr == "" {
-		return Config{}, errors.New("APP_BASE_URL environment variable must be set")
+		errs = append(errs, errors.New("APP_BASE_URL environment variable must be set"))
 	}
 
 	baseURL, err := url.Parse(baseURLStr)
 	if err != nil {
-		return Config{}, fmt.Errorf("invalid APP_BASE_URL: %w", err)
+		errs = append(errs, fmt.Errorf("invalid APP_BASE_URL: %w", err))
+		baseURL = &url.URL{}
 	}
 
 	basePath := baseURL.Path
@@ -90,7 +160,114 @@ func NewConfig() (Config, error) {
 	if !found {
 		metricsPath = "/metrics"
 	}
+	enableDebugEndpoints := false
+	if debugStr, found := os.LookupEnv("APP_ENABLE_DEBUG_ENDPOINTS"); found {
+		enableDebugEndpoints, err = strconv.ParseBool(debugStr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid APP_ENABLE_DEBUG_ENDPOINTS: %w", err))
+		}
+	}
+
+	fgaPollIntervalMs, err := envInt("APP_FGA_POLL_INTERVAL_MS", 1000, positive)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	oidcClockSkewSeconds, err := envInt("APP_OIDC_CLOCK_SKEW_SECONDS", 30, nonNegative)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	oidcClockSkew := time.Duration(oidcClockSkewSeconds) * time.Second
+	if oidcClockSkew > maxOIDCClockSkew {
+		errs = append(errs, fmt.Errorf("APP_OIDC_CLOCK_SKEW_SECONDS must not exceed %d", int(maxOIDCClockSkew.Seconds())))
+	}
+
+	oidcMaxSessionAgeSeconds, err := envInt("APP_OIDC_MAX_SESSION_AGE", maxAge, positive)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	fgaCountRateLimit := rate.Limit(1)
+	if rpsStr, found := os.LookupEnv("APP_RATE_LIMIT_FGA_COUNT_RPS"); found {
+		rps, err := strconv.ParseFloat(rpsStr, 64)
+		if err != nil || rps <= 0 {
+			errs = append(errs, fmt.Errorf("invalid APP_RATE_LIMIT_FGA_COUNT_RPS: %q", rpsStr))
+		} else {
+			fgaCountRateLimit = rate.Limit(rps)
+		}
+	}
+
+	// Zero keeps the SDK default; the SDK allows at most 15 retries after
+	// the first attempt.
+	fgaRetryMaxAttempts, err := envInt("APP_FGA_RETRY_MAX_ATTEMPTS", 0, func(v int) bool { return v >= 1 && v <= 16 })
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	dbWaitTimeoutSeconds, err := envInt("APP_DB_WAIT_TIMEOUT_SECONDS", 30, nonNegative)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	statementTimeoutMs, err := envInt("APP_POSTGRES_STATEMENT_TIMEOUT_MS", 30000, positive)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	postgresqlMetricsIntervalSeconds, err := envInt("APP_POSTGRES_METRICS_INTERVAL", 60, positive)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	dbMetricsIntervalSeconds, err := envInt("APP_DB_METRICS_INTERVAL", 15, positive)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	debugQueryMaxCost := 10000.0
+	if costStr, found := os.LookupEnv("APP_DEBUG_QUERY_MAX_COST"); found {
+		cost, err := strconv.ParseFloat(costStr, 64)
+		if err != nil || cost < 0 {
+			errs = append(errs, fmt.Errorf("invalid APP_DEBUG_QUERY_MAX_COST: %q", costStr))
+		} else {
+			debugQueryMaxCost = cost
+		}
+	}
+
+	rabbitmqStreamPort, err := envInt("RABBITMQ_STREAM_PORT", 5552, positive)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	rabbitmqChannelPoolSize, err := envInt("APP_RABBITMQ_CHANNEL_POOL_SIZE", amqputil.DefaultChannelPoolSize, nonNegative)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	rabbitmqMetricsIntervalSeconds, err := envInt("APP_RABBITMQ_METRICS_INTERVAL", 15, positive)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	var rabbitmqDLQSuffixes []string
+	for _, suffix := range strings.Split(os.Getenv("APP_RABBITMQ_DLQ_SUFFIX"), ",") {
+		if suffix = strings.TrimSpace(suffix); suffix != "" {
+			rabbitmqDLQSuffixes = append(rabbitmqDLQSuffixes, suffix)
+		}
+	}
+	rabbitmqDLQAlertThreshold, err := envInt("APP_RABBITMQ_DLQ_ALERT_THRESHOLD", 0, nonNegative)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	outboxPollIntervalMs, err := envInt("APP_OUTBOX_POLL_INTERVAL_MS", 1000, positive)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	rabbitmqNackAllMax, err := envInt("APP_RABBITMQ_NACK_ALL_MAX", 1000, positive)
+	if err != nil {
+		errs = append(errs, err)
+	}
 
+	if len(errs) > 0 {
+		return Config{}, errors.Join(errs...)
+	}
 	return Config{
 		BaseURL:     strings.TrimSuffix(baseURLStr, "/"),
 		BasePath:    basePath,
@@ -99,14 +276,157 @@ func NewConfig() (Config, error) {
 		Port:        port,
 		MetricsPort: metricsPort,
 		MetricsPath: metricsPath,
+
+		EnableDebugEndpoints: enableDebugEndpoints,
+		FGAPollInterval:      time.Duration(fgaPollIntervalMs) * time.Millisecond,
+		OIDCClockSkew:        oidcClockSkew,
+		OIDCMaxSessionAge:    time.Duration(oidcMaxSessionAgeSeconds) * time.Second,
+		FGACountRateLimit:    fgaCountRateLimit,
+		FGARetryMaxAttempts:  fgaRetryMaxAttempts,
+
+		DBWaitTimeout:             time.Duration(dbWaitTimeoutSeconds) * time.Second,
+		DBStatementTimeout:        time.Duration(statementTimeoutMs) * time.Millisecond,
+		PostgresqlMetricsInterval: time.Duration(postgresqlMetricsIntervalSeconds) * time.Second,
+		DBMetricsInterval:         time.Duration(dbMetricsIntervalSeconds) * time.Second,
+		DebugQueryMaxCost:         debugQueryMaxCost,
+
+		RabbitMQStreamPort:      rabbitmqStreamPort,
+		RabbitMQChannelPoolSize: rabbitmqChannelPoolSize,
+		RabbitMQMetricsInterval: time.Duration(rabbitmqMetricsIntervalSeconds) * time.Second,
+		OutboxPollInterval:      time.Duration(outboxPollIntervalMs) * time.Millisecond,
+		RabbitMQNackAllMax:      rabbitmqNackAllMax,
+
+		RabbitMQDLQSuffixes:       rabbitmqDLQSuffixes,
+		RabbitMQDLQAlertThreshold: rabbitmqDLQAlertThreshold,
 	}, nil
 }
 
+// splitErrors returns the errors joined in err, or err itself.
+func splitErrors(err error) []error {
+	if err == nil {
+		return nil
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
+
+// validateAbsoluteURL checks that the environment variable name, when set,
+// holds an absolute URL.
+func validateAbsoluteURL(name string) error {
+	str := os.Getenv(name)
+	if str == "" {
+		return nil
+	}
+	if u, err := url.Parse(str); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid %s: %q is not an absolute URL", name, str)
+	}
+	return nil
+}
+
+func validateSMTPConfig() []error {
+	if os.Getenv("SMTP_HOST") == "" {
+		return nil
+	}
+	var errs []error
+	if _, err := envInt("SMTP_PORT", 25, func(v int) bool { return v > 0 && v <= 65535 }); err != nil {
+		errs = append(errs, err)
+	}
+	switch security := os.Getenv("SMTP_TRANSPORT_SECURITY"); security {
+	case "", "none", "starttls", "tls":
+	default:
+		errs = append(errs, fmt.Errorf("invalid SMTP_TRANSPORT_SECURITY: %q", security))
+	}
+	return errs
+}
+
+func validateRabbitMQConfig() []error {
+	var errs []error
+	if err := validateAbsoluteURL("APP_RABBITMQ_MANAGEMENT_URL"); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := service.NewRabbitMQConfig(os.Getenv("RABBITMQ_CONNECT_STRING")); err != nil {
+		errs = append(errs, fmt.Errorf("invalid RABBITMQ_CONNECT_STRING: %w", err))
+	}
+	for i, uri := range strings.Split(os.Getenv("RABBITMQ_CONNECT_STRINGS"), ",") {
+		if uri = strings.TrimSpace(uri); uri == "" {
+			continue
+		}
+		if _, err := service.NewRabbitMQConfig(uri); err != nil {
+			errs = append(errs, fmt.Errorf("invalid RABBITMQ_CONNECT_STRINGS entry %d: %w", i, err))
+		}
+	}
+	return errs
+}
+
+func validatePostgresqlConfig() []error {
+	connectString := os.Getenv("POSTGRESQL_DB_CONNECT_STRING")
+	if connectString == "" {
+		return nil
+	}
+	if _, err := pgx.ParseConfig(connectString); err != nil {
+		return []error{fmt.Errorf("invalid POSTGRESQL_DB_CONNECT_STRING: %w", err)}
+	}
+	return nil
+}
+
+func validateOIDCConfig() []error {
+	if os.Getenv("APP_SECRET_KEY") == "" {
+		return []error{errors.New("APP_SECRET_KEY environment variable must be set")}
+	}
+	return nil
+}
+
+func validateFGAConfig() []error {
+	errs := splitErrors(errors.Join(
+		validateAbsoluteURL("FGA_HTTP_API_URL"),
+		validateAbsoluteURL("APP_FGA_OAUTH2_TOKEN_URL"),
+	))
+	if os.Getenv("APP_FGA_OAUTH2_TOKEN_URL") != "" {
+		// FGA_TOKEN is the subject token of the exchange.
+		for _, name := range []string{"APP_FGA_CLIENT_ID", "APP_FGA_CLIENT_SECRET", "FGA_TOKEN"} {
+			if os.Getenv(name) == "" {
+				errs = append(errs, fmt.Errorf("%s is required when APP_FGA_OAUTH2_TOKEN_URL is set", name))
+			}
+		}
+	}
+	return errs
+}
+
+func validateTracingConfig() []error {
+	return splitErrors(errors.Join(
+		validateAbsoluteURL("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		validateAbsoluteURL("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"),
+	))
+}
+
+// ValidateConfig checks the environment of every component and returns all
+// the problems found, so operators can fix them in one go.
+func ValidateConfig() []error {
+	_, err := NewConfig()
+	errs := splitErrors(err)
+	for _, validate := range []func() []error{
+		validateSMTPConfig,
+		validateRabbitMQConfig,
+		validatePostgresqlConfig,
+		validateOIDCConfig,
+		validateFGAConfig,
+		validateTracingConfig,
+	} {
+		errs = append(errs, validate()...)
+	}
+	return errs
+}
+
 type mainHandler struct {
 	counter prometheus.Counter
-	service service.Service
+	service *service.Service
 	config  Config
 	store   sessions.Store
+	// confirmToken must be sent as X-Confirm-Token to the most disruptive
+	// debug endpoints. It is generated at startup and only logged.
+	confirmToken string
 }
 
 // serveHelloWorld now acts as the main landing page with a login link.
@@ -116,6 +436,64 @@ func (h mainHandler) serveHelloWorld(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Hello, World!")
 }
 
+// debugOnly wraps handlers that mutate backing services so they are only
+// reachable when APP_ENABLE_DEBUG_ENDPOINTS is enabled.
+func (h mainHandler) debugOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.config.EnableDebugEndpoints {
+			http.NotFound(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// newConfirmToken returns a random 32-byte hex encoded token.
+func newConfirmToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// requireConfirmToken only calls next when the X-Confirm-Token header
+// matches the startup token, otherwise it counts the request in rejected
+// and answers 403.
+func (h mainHandler) requireConfirmToken(rejected prometheus.Counter, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-Confirm-Token")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(h.confirmToken)) != 1 {
+			rejected.Inc()
+			http.Error(w, "Missing or invalid X-Confirm-Token", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// rateLimited rejects requests beyond limit requests per second with 429.
+func rateLimited(limit rate.Limit, next http.HandlerFunc) http.HandlerFunc {
+	limiter := rate.NewLimiter(limit, 1)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !limiter.Allow() {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(1/float64(limit)))))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// writeJSON encodes v as the JSON response body with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding JSON response: %v", err)
+	}
+}
+
 func handleError(w http.ResponseWriter, error_message error) {
 	w.WriteHeader(http.StatusInternalServerError)
 	w.Header().Set("Content-Type", "application/json")
@@ -132,343 +510,2458 @@ func (h mainHandler) serveOpenFgaListAuthorizationModels(w http.ResponseWriter,
 	h.counter.Inc()
 	log.Printf("Counter %#v\n", h.counter)
 
-	fgaClient, err := NewSdkClient(&ClientConfiguration{
-		ApiUrl:  os.Getenv("FGA_HTTP_API_URL"),
-		StoreId: os.Getenv("FGA_STORE_ID"),
-		Credentials: &credentials.Credentials{
-			Method: credentials.CredentialsMethodApiToken,
-			Config: &credentials.Config{
-				ApiToken: os.Getenv("FGA_TOKEN"),
-			},
-		},
-	})
+	fgaClient, err := h.service.OpenFGAClient()
 	if err != nil {
 		handleError(w, err)
+		return
 	}
 
 	_, err = fgaClient.ReadAuthorizationModels(context.Background()).Execute()
 	if err != nil {
 		handleError(w, err)
+		return
 	}
 
 	fmt.Fprintf(w, "Listed authorization models")
 }
 
-func (h mainHandler) serveMail(w http.ResponseWriter, r *http.Request) {
-	h.counter.Inc()
-	log.Printf("Counter %#v\n", h.counter)
-
-	from := mail.Address{Name: "", Address: "tester@example.com"}
-	to := mail.Address{Name: "", Address: "test@example.com"}
-	subj := "hello"
-	body := "Hello world!"
+func (h mainHandler) serveOpenFgaListModelsByType(w http.ResponseWriter, r *http.Request) {
+	typeName := r.URL.Query().Get("type")
+	if typeName == "" {
+		http.Error(w, "Missing type query parameter", http.StatusBadRequest)
+		return
+	}
 
-	// Setup headers
-	headers := make(map[string]string)
-	headers["From"] = from.String()
-	headers["To"] = to.String()
-	headers["Subject"] = subj
+	modelIDs, err := h.service.OpenFGAListAuthorizationModelsByType(r.Context(), typeName)
+	if err != nil {
+		handleOpenFgaError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string][]string{"authorization_model_ids": modelIDs})
+}
 
-	// Setup message
-	message := ""
-	for k, v := range headers {
-		message += fmt.Sprintf("%s: %s\r\n", k, v)
+func (h mainHandler) serveOpenFgaRunAssertions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
-	message += "\r\n" + body
 
-	// Connect to the SMTP Server
-	smtp_host, _ := os.LookupEnv("SMTP_HOST")
-	smtp_port, _ := os.LookupEnv("SMTP_PORT")
-	smtp_servername := smtp_host + ":" + smtp_port
-	smtp_user, _ := os.LookupEnv("SMTP_USER")
-	smtp_domain, _ := os.LookupEnv("SMTP_DOMAIN")
-	smtp_password, _ := os.LookupEnv("SMTP_PASSWORD")
-	auth := smtp.PlainAuth("", smtp_user+"@"+smtp_domain, smtp_password, smtp_host)
-	smtp_transport_security, _ := os.LookupEnv("SMTP_TRANSPORT_SECURITY")
-	c, err := smtp.Dial(smtp_servername)
-	defer c.Quit()
+	report, err := h.service.OpenFGARunAssertions(r.Context(), r.PathValue("model_id"))
 	if err != nil {
 		handleError(w, err)
+		return
 	}
-	if smtp_transport_security == "starttls" {
-		// TLS config
-		tlsconfig := &tls.Config{
-			InsecureSkipVerify: true,
-			ServerName:         smtp_host,
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+// serveOpenFGATupleChanges streams tuple changes as Server-Sent Events. The
+// event ID is the OpenFGA continuation token, so a reconnecting client
+// resumes from it through the Last-Event-ID request header.
+func (h mainHandler) serveOpenFGATupleChanges(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	err := h.service.OpenFGATupleChanges(r.Context(), r.Header.Get("Last-Event-ID"), h.config.FGAPollInterval,
+		func(change openfga.TupleChange, token string) error {
+			data, err := json.Marshal(change)
+			if err != nil {
+				return err
+			}
+			if token != "" {
+				fmt.Fprintf(w, "id: %s\n", token)
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+			return nil
+		})
+	if err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("Tuple changes stream error: %v", err)
+		fmt.Fprintf(w, "event: error\ndata: %s\n\n", err)
+		flusher.Flush()
+	}
+}
+
+// serveOpenFgaImportTuples writes the JSON array of tuples in the request
+// body and streams the progress as one JSON object per line.
+func (h mainHandler) serveOpenFgaImportTuples(w http.ResponseWriter, r *http.Request) {
+	chunkSize := 50
+	if v := r.URL.Query().Get("chunk_size"); v != "" {
+		var err error
+		if chunkSize, err = strconv.Atoi(v); err != nil || chunkSize <= 0 || chunkSize > 100 {
+			http.Error(w, "Invalid chunk_size query parameter, must be between 1 and 100", http.StatusBadRequest)
+			return
 		}
-		c.StartTLS(tlsconfig)
+	}
+	var tuples []openfga.TupleKey
+	if err := json.NewDecoder(r.Body).Decode(&tuples); err != nil {
+		http.Error(w, "Request body must be a JSON array of tuples", http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
 	}
 
-	// Auth
-	if smtp_transport_security == "tls" {
-		if err = c.Auth(auth); err != nil {
-			handleError(w, err)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	err := h.service.OpenFGAImportTuples(r.Context(), tuples, chunkSize, func(written int) error {
+		if err := encoder.Encode(map[string]int{"written": written, "total": len(tuples)}); err != nil {
+			return err
 		}
+		flusher.Flush()
+		return nil
+	})
+	if err != nil {
+		log.Printf("Tuple import error: %v", err)
+		encoder.Encode(map[string]string{"error": err.Error()})
+		return
 	}
+	encoder.Encode(map[string]any{"done": true, "total": len(tuples)})
+}
 
-	// To && From
-	if err = c.Mail(from.Address); err != nil {
+// handleOpenFgaError maps OpenFGA client errors to HTTP responses.
+func handleOpenFgaError(w http.ResponseWriter, err error) {
+	var notFound openfga.FgaApiNotFoundError
+	var validation openfga.FgaApiValidationError
+	switch {
+	case errors.Is(err, service.ErrNoAuthorizationModel), errors.As(err, &notFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, service.ErrInvalidAuthorizationModel), errors.As(err, &validation):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("OpenFGA error: %v", err)
 		handleError(w, err)
 	}
+}
 
-	if err = c.Rcpt(to.Address); err != nil {
+func (h mainHandler) serveOpenFgaGetModel(w http.ResponseWriter, r *http.Request) {
+	model, err := h.service.OpenFGAReadAuthorizationModel(r.Context(), r.PathValue("id"))
+	if err != nil {
+		handleOpenFgaError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, model)
+}
+
+func (h mainHandler) serveOpenFgaCreateStore(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Name == "" {
+		http.Error(w, "Request body must be a JSON object with a name", http.StatusBadRequest)
+		return
+	}
+
+	storeID, err := h.service.OpenFGACreateStore(r.Context(), request.Name)
+	if err != nil {
 		handleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"store_id": storeID})
+}
+
+func (h mainHandler) serveOpenFgaModelDiff(w http.ResponseWriter, r *http.Request) {
+	from, to := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+	if from == "" || to == "" {
+		http.Error(w, "Both from and to query parameters are required", http.StatusBadRequest)
+		return
 	}
 
-	// Data
-	m, err := c.Data()
+	diff, err := h.service.OpenFGAModelDiff(r.Context(), from, to)
+	if err != nil {
+		handleOpenFgaError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, diff)
+}
+
+// serveOpenFgaMigrateModel writes the authorization model in the request
+// body, in the JSON format of the OpenFGA API, and returns its ID.
+func (h mainHandler) serveOpenFgaMigrateModel(w http.ResponseWriter, r *http.Request) {
+	schema, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	modelID, err := h.service.OpenFGAMigrateModel(r.Context(), string(schema))
+	if err != nil && modelID == "" {
+		handleOpenFgaError(w, err)
+		return
+	}
 	if err != nil {
+		log.Printf("Model migration error: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"authorization_model_id": modelID, "error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"authorization_model_id": modelID})
+}
+
+// serveOpenFgaReindex flushes the in-process OpenFGA state after a model
+// change.
+func (h mainHandler) serveOpenFgaReindex(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Flushing the OpenFGA client cache")
+	if err := h.service.OpenFGAReindexTuples(r.Context()); err != nil {
+		handleOpenFgaError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "reindexed"})
+}
+
+func (h mainHandler) serveOpenFgaListStores(w http.ResponseWriter, r *http.Request) {
+	stores, err := h.service.OpenFGAListStores(r.Context())
+	if err != nil {
+		handleOpenFgaError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string][]service.StoreInfo{"stores": stores})
+}
+
+func (h mainHandler) serveOpenFgaDeleteStore(w http.ResponseWriter, r *http.Request) {
+	if err := h.service.OpenFGADeleteStore(r.Context(), r.PathValue("id")); err != nil {
 		handleError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h mainHandler) serveOpenFgaTupleCount(w http.ResponseWriter, r *http.Request) {
+	count, err := h.service.OpenFGATupleCount(r.Context())
+	if err != nil {
+		handleOpenFgaError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"count": count})
+}
+
+// serveOpenFgaTupleSearch returns a page of the tuples matching the user,
+// relation and object, or object_type, query parameters. The Read API needs
+// an object or object type to search by user. The URL of the next page, if
+// any, is given in a Link header.
+func (h mainHandler) serveOpenFgaTupleSearch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	pageSize := 50
+	if v := query.Get("page_size"); v != "" {
+		var err error
+		if pageSize, err = strconv.Atoi(v); err != nil || pageSize <= 0 || pageSize > service.FGAReadPageSize {
+			http.Error(w, "Invalid page_size query parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	filter := service.TupleFilter{
+		User:       query.Get("user"),
+		Relation:   query.Get("relation"),
+		Object:     query.Get("object"),
+		ObjectType: query.Get("object_type"),
+	}
+	if filter.User != "" && filter.Object == "" && filter.ObjectType == "" {
+		http.Error(w, "The object or object_type query parameter is required with user", http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.service.OpenFGATupleSearch(r.Context(), filter, pageSize, query.Get("cursor"))
+	if err != nil {
+		handleOpenFgaError(w, err)
+		return
+	}
+	if page.NextCursor != "" {
+		setNextLink(w, r, page.NextCursor)
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// setNextLink sets a Link header pointing at the request URL with its
+// cursor query parameter replaced by cursor.
+func setNextLink(w http.ResponseWriter, r *http.Request, cursor string) {
+	next := *r.URL
+	query := next.Query()
+	query.Set("cursor", cursor)
+	next.RawQuery = query.Encode()
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.RequestURI()))
+}
+
+// serveOpenFgaListUsers returns a page of the users of user_type with the
+// relation on the object. The API returns all users at once, so the
+// cursor is the offset of the page in the sorted list.
+func (h mainHandler) serveOpenFgaListUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	object, relation, userType := query.Get("object"), query.Get("relation"), query.Get("user_type")
+	if object == "" || relation == "" || userType == "" {
+		http.Error(w, "The object, relation and user_type query parameters are required", http.StatusBadRequest)
+		return
+	}
+	pageSize := 50
+	if v := query.Get("page_size"); v != "" {
+		var err error
+		if pageSize, err = strconv.Atoi(v); err != nil || pageSize <= 0 || pageSize > service.FGAReadPageSize {
+			http.Error(w, "Invalid page_size query parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	offset := 0
+	if v := query.Get("cursor"); v != "" {
+		var err error
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			http.Error(w, "Invalid cursor query parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	users, err := h.service.OpenFGAListUsers(r.Context(), object, relation, userType)
+	if errors.Is(err, service.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		handleOpenFgaError(w, err)
+		return
+	}
+	if offset >= len(users) {
+		writeJSON(w, http.StatusOK, map[string][]string{"users": {}})
+		return
+	}
+	end := min(offset+pageSize, len(users))
+	if end < len(users) {
+		setNextLink(w, r, strconv.Itoa(end))
+	}
+	writeJSON(w, http.StatusOK, map[string][]string{"users": users[offset:end]})
+}
+
+// maxBenchmarkConcurrency bounds the checks serveOpenFgaBenchmark keeps in
+// flight.
+const maxBenchmarkConcurrency = 100
+
+func (h mainHandler) serveOpenFgaBenchmark(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Checks      []service.CheckRequest `json:"checks"`
+		Concurrency int                    `json:"concurrency"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Checks) == 0 {
+		http.Error(w, "Request body must be a JSON object with a non-empty checks array", http.StatusBadRequest)
+		return
+	}
+	if request.Concurrency == 0 {
+		request.Concurrency = 1
+	}
+	if request.Concurrency < 0 || request.Concurrency > maxBenchmarkConcurrency {
+		http.Error(w, fmt.Sprintf("concurrency must be between 1 and %d", maxBenchmarkConcurrency), http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.service.OpenFGARunAuthorizationEvaluation(r.Context(), request.Checks, request.Concurrency)
+	if err != nil {
+		handleOpenFgaError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (h mainHandler) serveMail(w http.ResponseWriter, r *http.Request) {
+	h.counter.Inc()
+	log.Printf("Counter %#v\n", h.counter)
+
+	from := mail.Address{Name: "", Address: "tester@example.com"}
+	to := mail.Address{Name: "", Address: "test@example.com"}
+	subj := "hello"
+	body := "Hello world!"
+
+	// Setup headers
+	headers := make(map[string]string)
+	headers["From"] = from.String()
+	headers["To"] = to.String()
+	headers["Subject"] = subj
+
+	// Setup message
+	message := ""
+	for k, v := range headers {
+		message += fmt.Sprintf("%s: %s\r\n", k, v)
+	}
+	message += "\r\n" + body
+
+	// Connect to the SMTP Server
+	smtp_host, _ := os.LookupEnv("SMTP_HOST")
+	smtp_port, _ := os.LookupEnv("SMTP_PORT")
+	smtp_servername := smtp_host + ":" + smtp_port
+	smtp_user, _ := os.LookupEnv("SMTP_USER")
+	smtp_domain, _ := os.LookupEnv("SMTP_DOMAIN")
+	smtp_password, _ := os.LookupEnv("SMTP_PASSWORD")
+	auth := smtp.PlainAuth("", smtp_user+"@"+smtp_domain, smtp_password, smtp_host)
+	smtp_transport_security, _ := os.LookupEnv("SMTP_TRANSPORT_SECURITY")
+	c, err := smtp.Dial(smtp_servername)
+	defer c.Quit()
+	if err != nil {
+		handleError(w, err)
+	}
+	if smtp_transport_security == "starttls" {
+		// TLS config
+		tlsconfig := &tls.Config{
+			InsecureSkipVerify: true,
+			ServerName:         smtp_host,
+		}
+		c.StartTLS(tlsconfig)
+	}
+
+	// Auth
+	if smtp_transport_security == "tls" {
+		if err = c.Auth(auth); err != nil {
+			handleError(w, err)
+		}
+	}
+
+	// To && From
+	if err = c.Mail(from.Address); err != nil {
+		handleError(w, err)
+	}
+
+	if err = c.Rcpt(to.Address); err != nil {
+		handleError(w, err)
+	}
+
+	// Data
+	m, err := c.Data()
+	if err != nil {
+		handleError(w, err)
+	}
+
+	_, err = m.Write([]byte(message))
+	if err != nil {
+		handleError(w, err)
+	}
+
+	err = m.Close()
+	if err != nil {
+		handleError(w, err)
+	}
+
+	fmt.Fprintf(w, "Sent")
+}
+
+func (h mainHandler) serveUserDefinedConfig(w http.ResponseWriter, r *http.Request) {
+	h.counter.Inc()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	user_defined_config, found := os.LookupEnv("APP_USER_DEFINED_CONFIG")
+	if !found {
+		json.NewEncoder(w).Encode(nil)
+		return
+	}
+	json.NewEncoder(w).Encode(user_defined_config)
+}
+
+func (h mainHandler) servePostgresql(w http.ResponseWriter, r *http.Request) {
+	err := h.service.CheckPostgresqlMigrateStatus()
+	if err != nil {
+		log.Printf(err.Error())
+		io.WriteString(w, "FAILURE")
+		return
+	} else {
+		io.WriteString(w, "SUCCESS")
+	}
+}
+
+func (h mainHandler) servePostgresqlTransaction(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Statements []string `json:"statements"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Statements) == 0 {
+		http.Error(w, "Request body must be a JSON object with a non-empty statements list", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.PostgresqlExecuteTransaction(r.Context(), request.Statements); err != nil {
+		log.Printf("Transaction error: %v", err)
+		handleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"executed": len(request.Statements)})
+}
+
+func (h mainHandler) servePostgresqlKillIdle(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		OlderThanSeconds int `json:"older_than_seconds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.OlderThanSeconds <= 0 {
+		http.Error(w, "Request body must be a JSON object with a positive older_than_seconds", http.StatusBadRequest)
+		return
+	}
+
+	terminated, err := h.service.PostgresqlKillIdleConnections(r.Context(), time.Duration(request.OlderThanSeconds)*time.Second)
+	if err != nil {
+		log.Printf("Kill idle connections error: %v", err)
+		handleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"terminated": terminated})
+}
+
+var vacuumFullRejectedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "vacuum_full_rejected_total",
+	Help: "No of VACUUM FULL requests rejected for a missing or invalid confirmation token",
+})
+
+func (h mainHandler) servePostgresqlVacuumFull(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Table string `json:"table"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Table == "" {
+		http.Error(w, "Request body must be a JSON object with a table", http.StatusBadRequest)
+		return
+	}
+
+	err := h.service.PostgresqlVacuumFull(r.Context(), request.Table)
+	if errors.Is(err, service.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Vacuum full error: %v", err)
+		handleError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h mainHandler) servePostgresqlAnalyze(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Table string `json:"table"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Request body must be a JSON object", http.StatusBadRequest)
+		return
+	}
+
+	err := h.service.PostgresqlRunAnalyze(r.Context(), request.Table)
+	if errors.Is(err, service.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Analyze error: %v", err)
+		handleError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// defaultChunkInterval is the TimescaleDB default chunk_time_interval, used
+// when the request of servePostgresqlCreateHypertable gives none.
+const defaultChunkInterval = 7 * 24 * time.Hour
+
+func (h mainHandler) servePostgresqlCreateHypertable(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Table         string `json:"table"`
+		TimeColumn    string `json:"time_column"`
+		ChunkInterval string `json:"chunk_interval"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Table == "" || request.TimeColumn == "" {
+		http.Error(w, "Request body must be a JSON object with a table and a time_column", http.StatusBadRequest)
+		return
+	}
+	chunkInterval := defaultChunkInterval
+	if request.ChunkInterval != "" {
+		var err error
+		if chunkInterval, err = time.ParseDuration(request.ChunkInterval); err != nil || chunkInterval <= 0 {
+			http.Error(w, "Invalid chunk_interval, expected a positive duration such as 24h", http.StatusBadRequest)
+			return
+		}
+	}
+
+	err := h.service.PostgresqlCreateHypertable(r.Context(), request.Table, request.TimeColumn, chunkInterval)
+	if errors.Is(err, service.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, service.ErrTimescaleNotInstalled) {
+		http.Error(w, "timescaledb is not installed, run CREATE EXTENSION timescaledb "+
+			"with the library in shared_preload_libraries", http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		log.Printf("Create hypertable error: %v", err)
+		handleError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h mainHandler) servePostgresqlActiveQueries(w http.ResponseWriter, r *http.Request) {
+	longerThanMs := 0
+	if v := r.URL.Query().Get("longer_than_ms"); v != "" {
+		var err error
+		if longerThanMs, err = strconv.Atoi(v); err != nil || longerThanMs < 0 {
+			http.Error(w, "Invalid longer_than_ms query parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	queries, err := h.service.PostgresqlActiveQueries(r.Context(), longerThanMs)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, queries)
+}
+
+func (h mainHandler) servePostgresqlPreparedStatements(w http.ResponseWriter, r *http.Request) {
+	statements, err := h.service.PostgresqlPreparedStatements(r.Context())
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string][]service.PreparedStatement{"prepared_statements": statements})
+}
+
+func (h mainHandler) servePostgresqlExtensions(w http.ResponseWriter, r *http.Request) {
+	extensions, err := h.service.PostgresqlListExtensions(r.Context())
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string][]service.Extension{"extensions": extensions})
+}
+
+func (h mainHandler) servePostgresqlEnableExtension(w http.ResponseWriter, r *http.Request) {
+	err := h.service.PostgresqlEnableExtension(r.Context(), r.PathValue("name"))
+	if errors.Is(err, service.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Enable extension error: %v", err)
+		handleError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h mainHandler) servePostgresqlDisableExtension(w http.ResponseWriter, r *http.Request) {
+	err := h.service.PostgresqlDisableExtension(r.Context(), r.PathValue("name"))
+	if errors.Is(err, service.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Disable extension error: %v", err)
+		handleError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h mainHandler) servePostgresqlSlowQueries(w http.ResponseWriter, r *http.Request) {
+	limit := 10
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit query parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	queries, err := h.service.PostgresqlSlowQueryLog(r.Context(), limit)
+	if errors.Is(err, service.ErrPgStatStatementsNotInstalled) {
+		http.Error(w, "pg_stat_statements is not installed, run CREATE EXTENSION pg_stat_statements "+
+			"with the library in shared_preload_libraries", http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, queries)
+}
+
+func (h mainHandler) servePostgresqlCreateRole(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Name  string `json:"name"`
+		Login bool   `json:"login"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Name == "" {
+		http.Error(w, "Request body must be a JSON object with a name", http.StatusBadRequest)
+		return
+	}
+
+	err := h.service.PostgresqlCreateRole(r.Context(), request.Name, request.Login)
+	if errors.Is(err, service.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Create role error: %v", err)
+		handleError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h mainHandler) servePostgresqlGrant(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Role      string `json:"role"`
+		Table     string `json:"table"`
+		Privilege string `json:"privilege"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Role == "" || request.Table == "" || request.Privilege == "" {
+		http.Error(w, "Request body must be a JSON object with a role, table and privilege", http.StatusBadRequest)
+		return
+	}
+
+	err := h.service.PostgresqlGrantRole(r.Context(), request.Role, request.Table, request.Privilege)
+	if errors.Is(err, service.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Grant error: %v", err)
+		handleError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h mainHandler) servePostgresqlLocks(w http.ResponseWriter, r *http.Request) {
+	locks, err := h.service.PostgresqlCheckLocks(r.Context())
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, locks)
+}
+
+func (h mainHandler) servePostgresqlCancelQuery(w http.ResponseWriter, r *http.Request) {
+	pid, err := strconv.Atoi(r.PathValue("pid"))
+	if err != nil || pid <= 0 {
+		http.Error(w, "Invalid pid", http.StatusBadRequest)
+		return
+	}
+
+	cancelled, err := h.service.PostgresqlCancelQuery(r.Context(), pid)
+	if err != nil {
+		log.Printf("Cancel query error: %v", err)
+		handleError(w, err)
+		return
+	}
+	if !cancelled {
+		writeJSON(w, http.StatusOK, map[string]any{"cancelled": false, "reason": "process not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"cancelled": true})
+}
+
+// servePostgresqlQuery runs the query of the request and returns its rows.
+// Queries the planner estimates to cost more than DebugQueryMaxCost, or
+// that it cannot plan, are refused with 422 before they run.
+func (h mainHandler) servePostgresqlQuery(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Query string `json:"query"`
+		Args  []any  `json:"args"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Query == "" {
+		http.Error(w, "Request body must be a JSON object with a query", http.StatusBadRequest)
+		return
+	}
+
+	if h.config.DebugQueryMaxCost > 0 {
+		err := h.service.PostgresqlExplainBudget(r.Context(), request.Query, request.Args, h.config.DebugQueryMaxCost)
+		if errors.Is(err, service.ErrQueryTooExpensive) || errors.Is(err, service.ErrQueryNotPlannable) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if err != nil {
+			log.Printf("Query budget error: %v", err)
+			handleError(w, err)
+			return
+		}
+	}
+
+	result, err := h.service.PostgresqlQuery(r.Context(), request.Query, request.Args)
+	if err != nil {
+		log.Printf("Query error: %v", err)
+		handleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"rows": result})
+}
+
+func (h mainHandler) servePostgresqlQueryInSchema(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Query string `json:"query"`
+		Args  []any  `json:"args"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Query == "" {
+		http.Error(w, "Request body must be a JSON object with a query", http.StatusBadRequest)
+		return
+	}
+
+	var result []map[string]any
+	err := h.service.PostgresqlWithSchema(r.Context(), r.PathValue("name"), func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(r.Context(), request.Query, request.Args...)
+		if err != nil {
+			return err
+		}
+		result, err = service.ScanRows(rows)
+		return err
+	})
+	if errors.Is(err, service.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Query in schema error: %v", err)
+		handleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"rows": result})
+}
+
+func (h mainHandler) servePostgresqlInstallNotifier(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Channel string `json:"channel"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Channel == "" {
+		http.Error(w, "Request body must be a JSON object with a channel", http.StatusBadRequest)
+		return
+	}
+
+	err := h.service.PostgresqlInstallChangeNotifier(r.Context(), r.PathValue("table"), request.Channel)
+	if errors.Is(err, service.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Install change notifier error: %v", err)
+		handleError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h mainHandler) servePostgresqlRemoveNotifier(w http.ResponseWriter, r *http.Request) {
+	err := h.service.PostgresqlRemoveChangeNotifier(r.Context(), r.PathValue("table"))
+	if errors.Is(err, service.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Remove change notifier error: %v", err)
+		handleError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h mainHandler) servePostgresqlCreateAuditTrigger(w http.ResponseWriter, r *http.Request) {
+	err := h.service.PostgresqlCreateAuditTrigger(r.Context(), r.PathValue("table"))
+	if errors.Is(err, service.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Create audit trigger error: %v", err)
+		handleError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h mainHandler) servePostgresqlDropAuditTrigger(w http.ResponseWriter, r *http.Request) {
+	err := h.service.PostgresqlDropAuditTrigger(r.Context(), r.PathValue("table"))
+	if errors.Is(err, service.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Drop audit trigger error: %v", err)
+		handleError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// servePostgresqlNotify streams the notifications sent on a channel as
+// Server-Sent Events.
+func (h mainHandler) servePostgresqlNotify(w http.ResponseWriter, r *http.Request) {
+	channel := r.PathValue("channel")
+	if err := service.ValidateIdentifier(channel); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	err := h.service.PostgresqlListen(r.Context(), channel, func(payload string) error {
+		fmt.Fprintf(w, "data: %s\n\n", payload)
+		flusher.Flush()
+		return nil
+	})
+	if err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("PostgreSQL notify stream error: %v", err)
+		fmt.Fprintf(w, "event: error\ndata: %s\n\n", err)
+		flusher.Flush()
+	}
+}
+
+// csvAttachmentWriter sets the CSV download headers on the first write, so
+// errors before any output can still be reported with a status code.
+type csvAttachmentWriter struct {
+	w       http.ResponseWriter
+	written bool
+}
+
+func (c *csvAttachmentWriter) Write(p []byte) (int, error) {
+	if !c.written {
+		c.w.Header().Set("Content-Type", "text/csv")
+		c.w.Header().Set("Content-Disposition", `attachment; filename="export.csv"`)
+		c.written = true
+	}
+	return c.w.Write(p)
+}
+
+func (h mainHandler) servePostgresqlExportCSV(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("query")
+	if query == "" {
+		http.Error(w, "Missing query parameter", http.StatusBadRequest)
+		return
+	}
+
+	out := &csvAttachmentWriter{w: w}
+	err := h.service.PostgresqlCopyTo(r.Context(), query, out)
+	if errors.Is(err, service.ErrQueryNotReadOnly) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("CSV export error: %v", err)
+		if !out.written {
+			handleError(w, err)
+		}
+	}
+}
+
+// maxBulkInsertBytes bounds the request body servePostgresqlBulkInsert
+// streams into PostgreSQL.
+const maxBulkInsertBytes = 64 << 20
+
+// servePostgresqlBulkInsert copies the CSV request body, with a header row,
+// into a table. The columns query parameter lists the target columns of the
+// CSV fields, in order.
+func (h mainHandler) servePostgresqlBulkInsert(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "text/csv" {
+		http.Error(w, "Content-Type must be text/csv", http.StatusUnsupportedMediaType)
+		return
+	}
+	var columns []string
+	if v := r.URL.Query().Get("columns"); v != "" {
+		columns = strings.Split(v, ",")
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxBulkInsertBytes)
+	rows, err := h.service.PostgresqlCopyFromCSV(r.Context(), r.PathValue("table"), columns, body)
+	if errors.Is(err, service.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		log.Printf("Bulk insert error: %v", err)
+		handleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int64{"inserted": rows})
+}
+
+func (h mainHandler) servePostgresqlTableSizes(w http.ResponseWriter, r *http.Request) {
+	sizes, err := h.service.PostgresqlTableSize(r.Context())
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sizes)
+}
+
+func (h mainHandler) servePostgresqlTableStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.service.PostgresqlExtendedStats(r.Context())
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func (h mainHandler) servePostgresqlSSLStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := h.service.PostgresqlSSLStatus(r.Context())
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (h mainHandler) servePostgresqlWalStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := h.service.PostgresqlWalStatus(r.Context())
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (h mainHandler) servePostgresqlSequences(w http.ResponseWriter, r *http.Request) {
+	sequences, err := h.service.PostgresqlSequenceStatus(r.Context())
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sequences)
+}
+
+var (
+	postgresSequencePercentUsed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "postgres_sequence_percent_used",
+		Help: "Percentage of its range a PostgreSQL sequence has used",
+	}, []string{"sequence"})
+	postgresTableStats = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "postgres_table_stats",
+		Help: "PostgreSQL table statistics from pg_stat_user_tables and pg_statio_user_tables",
+	}, []string{"table", "stat"})
+	postgresWalLagBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "postgres_wal_lag_bytes",
+		Help: "Bytes of WAL a PostgreSQL replica has not replayed yet; \"local\" is this server when it is a replica",
+	}, []string{"replica"})
+	postgresConnectionsByState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "postgres_connections",
+		Help: "PostgreSQL server connections by state and application_name",
+	}, []string{"state", "application"})
+)
+
+// reportPostgresqlMetrics refreshes the sequence usage, table statistics,
+// WAL lag and connection gauges every interval until ctx is done.
+func reportPostgresqlMetrics(ctx context.Context, svc *service.Service, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		sequences, err := svc.PostgresqlSequenceStatus(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("PostgreSQL sequence status error: %v", err)
+		}
+		if err == nil {
+			// Drop sequences that no longer exist.
+			postgresSequencePercentUsed.Reset()
+			for _, sequence := range sequences {
+				postgresSequencePercentUsed.WithLabelValues(sequence.Sequence).Set(sequence.PercentUsed)
+			}
+		}
+
+		tables, err := svc.PostgresqlExtendedStats(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("PostgreSQL table stats error: %v", err)
+		}
+		if err == nil {
+			postgresTableStats.Reset()
+			for _, table := range tables {
+				for stat, value := range map[string]int64{
+					"seq_scan":       table.SeqScan,
+					"idx_scan":       table.IdxScan,
+					"n_dead_tup":     table.DeadTuples,
+					"n_live_tup":     table.LiveTuples,
+					"heap_blks_hit":  table.HeapBlksHit,
+					"heap_blks_read": table.HeapBlksRead,
+				} {
+					postgresTableStats.WithLabelValues(table.Table, stat).Set(float64(value))
+				}
+			}
+		}
+
+		wal, err := svc.PostgresqlWalStatus(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("PostgreSQL WAL status error: %v", err)
+		}
+		if err == nil {
+			postgresWalLagBytes.Reset()
+			if wal.IsReplica {
+				postgresWalLagBytes.WithLabelValues("local").Set(float64(wal.ReplayLagBytes))
+			}
+			for _, replica := range wal.Replicas {
+				postgresWalLagBytes.WithLabelValues(replica.Name).Set(float64(replica.LagBytes))
+			}
+		}
+
+		connections, err := svc.PostgresqlConnectionCount(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("PostgreSQL connection count error: %v", err)
+		}
+		if err == nil {
+			postgresConnectionsByState.Reset()
+			for _, connection := range connections {
+				postgresConnectionsByState.WithLabelValues(connection.State, connection.ApplicationName).Set(float64(connection.Count))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h mainHandler) servePostgresqlPoolStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.service.PostgresqlConnectionPoolStats())
+}
+
+var (
+	postgresPoolOpen = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "postgres_pool_open_connections",
+		Help: "No of open connections in the PostgreSQL pool",
+	})
+	postgresPoolInUse = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "postgres_pool_in_use_connections",
+		Help: "No of PostgreSQL pool connections in use",
+	})
+	postgresPoolIdle = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "postgres_pool_idle_connections",
+		Help: "No of idle PostgreSQL pool connections",
+	})
+)
+
+// newPostgresPoolWaitTotal returns the counter of the times a query of svc
+// waited for a pool connection, read from the cumulative pool statistics.
+func newPostgresPoolWaitTotal(svc *service.Service) prometheus.CounterFunc {
+	return prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "postgres_pool_wait_total",
+		Help: "No of times a query waited for a PostgreSQL pool connection",
+	}, func() float64 {
+		return float64(svc.PostgresqlConnectionPoolStats().WaitCount)
+	})
+}
+
+// postgresqlStartupTimeout bounds how long checkPostgresqlSSL waits for
+// PostgreSQL to accept connections.
+const postgresqlStartupTimeout = time.Minute
+
+// checkPostgresqlSSL logs a warning once PostgreSQL is reachable if the
+// connections to it are not encrypted.
+func checkPostgresqlSSL(ctx context.Context, svc *service.Service) {
+	ctx, cancel := context.WithTimeout(ctx, postgresqlStartupTimeout)
+	defer cancel()
+	if err := svc.PostgresqlWaitForReady(ctx, time.Second); err != nil {
+		log.Printf("PostgreSQL SSL check skipped: %v", err)
+		return
+	}
+	status, err := svc.PostgresqlSSLStatus(ctx)
+	if err != nil {
+		log.Printf("PostgreSQL SSL check error: %v", err)
+		return
+	}
+	if !status.SSL {
+		log.Printf("WARNING: the PostgreSQL connection is not encrypted")
+		return
+	}
+	log.Printf("PostgreSQL connection encrypted with %s (%s, %d bits)", status.TLSVersion, status.Cipher, status.Bits)
+}
+
+// reportPostgresqlPoolMetrics refreshes the connection pool gauges every
+// interval until ctx is done.
+func reportPostgresqlPoolMetrics(ctx context.Context, svc *service.Service, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		stats := svc.PostgresqlConnectionPoolStats()
+		postgresPoolOpen.Set(float64(stats.OpenConnections))
+		postgresPoolInUse.Set(float64(stats.InUse))
+		postgresPoolIdle.Set(float64(stats.Idle))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h mainHandler) servePostgresqlIndexes(w http.ResponseWriter, r *http.Request) {
+	indexes, err := h.service.PostgresqlListIndexes(r.Context(), r.PathValue("table"))
+	if errors.Is(err, service.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, indexes)
+}
+
+func (h mainHandler) servePostgresqlConstraints(w http.ResponseWriter, r *http.Request) {
+	constraints, err := h.service.PostgresqlListConstraints(r.Context(), r.PathValue("table"))
+	if errors.Is(err, service.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, constraints)
+}
+
+func (h mainHandler) servePostgresqlPartitions(w http.ResponseWriter, r *http.Request) {
+	partitions, err := h.service.PostgresqlPartitionInfo(r.Context(), r.PathValue("table"))
+	if errors.Is(err, service.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, partitions)
+}
+
+func (h mainHandler) servePostgresqlRowCount(w http.ResponseWriter, r *http.Request) {
+	exact := false
+	if v := r.URL.Query().Get("exact"); v != "" {
+		var err error
+		if exact, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "Invalid exact query parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	table := r.PathValue("table")
+	count, err := h.service.PostgresqlRowCount(r.Context(), table, exact)
+	if errors.Is(err, service.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, service.ErrTableNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	note := "Planner estimate from pg_class.reltuples; cheap but only as fresh as the last VACUUM or ANALYZE, -1 if never analyzed."
+	if exact {
+		note = "Exact count(*); scans the whole table and can be slow on large tables."
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"table": table,
+		"count": count,
+		"exact": exact,
+		"note":  note,
+	})
+}
+
+func (h mainHandler) servePostgresqlCreateIndex(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Table  string `json:"table"`
+		Name   string `json:"name"`
+		Column string `json:"column"`
+		Unique bool   `json:"unique"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON request body", http.StatusBadRequest)
+		return
+	}
+
+	err := h.service.PostgresqlCreateIndexConcurrently(r.Context(), request.Table, request.Name, request.Column, request.Unique)
+	if errors.Is(err, service.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"index": request.Name})
+}
+
+// ValidateIDToken checks the exp, iat and nbf claims of an ID token against
+// now, tolerating up to skew of clock drift with the identity provider. On
+// exp the skew is capped at gothOIDCExpirySkew, the tolerance goth already
+// applied, so the result does not depend on which check ran first.
+// The signature is not checked: the token was received directly from the
+// token endpoint over TLS.
+func ValidateIDToken(idToken string, now time.Time, skew time.Duration) error {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed ID token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("malformed ID token payload: %w", err)
+	}
+	var claims struct {
+		Exp *float64 `json:"exp"`
+		Iat *float64 `json:"iat"`
+		Nbf *float64 `json:"nbf"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return fmt.Errorf("malformed ID token claims: %w", err)
+	}
+
+	claimTime := func(v float64) time.Time { return time.Unix(int64(v), 0) }
+	if claims.Exp == nil {
+		return errors.New("ID token has no exp claim")
+	}
+	if now.Add(-min(skew, gothOIDCExpirySkew)).After(claimTime(*claims.Exp)) {
+		return errors.New("ID token is expired")
+	}
+	if claims.Iat != nil && claimTime(*claims.Iat).After(now.Add(skew)) {
+		return errors.New("ID token issued in the future")
+	}
+	if claims.Nbf != nil && claimTime(*claims.Nbf).After(now.Add(skew)) {
+		return errors.New("ID token not valid yet")
+	}
+	return nil
+}
+
+// OIDC-specific: callback handler now shows the user data directly.
+func (h mainHandler) serveAuthCallback(w http.ResponseWriter, r *http.Request) {
+	user, err := gothic.CompleteUserAuth(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := ValidateIDToken(user.IDToken, time.Now(), h.config.OIDCClockSkew); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	session, err := h.store.New(r, SessionName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	userMap := map[string]string{
+		"sub":      user.UserID,
+		"email":    user.Email,
+		"provider": user.Provider,
+		// auth_at bounds the session lifetime even if the cookie is kept.
+		"auth_at": strconv.FormatInt(time.Now().Unix(), 10),
+	}
+
+	userData, err := json.Marshal(userMap)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	session.Values["user"] = userData
+	err = h.store.Save(r, w, session)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	profileURL := h.config.BaseURL + "/profile"
+	w.Header().Set("Location", profileURL)
+	w.WriteHeader(http.StatusTemporaryRedirect)
+}
+
+// OIDC-specific: logout handler
+func (h mainHandler) serveLogout(w http.ResponseWriter, r *http.Request) {
+	gothic.Logout(w, r)
+	session, err := h.store.New(r, SessionName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Set MaxAge to -1. This effectively deletes the cookie.
+	session.Options.MaxAge = -1
+	err = h.store.Save(r, w, session)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	homeURL := h.config.BaseURL
+	if homeURL == "" {
+		homeURL = "/"
+	}
+	w.Header().Set("Location", homeURL)
+	w.WriteHeader(http.StatusTemporaryRedirect)
+}
+
+func (h mainHandler) serveProfile(w http.ResponseWriter, r *http.Request) {
+	session, err := h.store.Get(r, SessionName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	userData, ok := session.Values["user"]
+	if !ok {
+		http.Error(w, "User not authenticated.", http.StatusForbidden)
+		return
+	}
+
+	userDataBytes, ok := userData.([]byte)
+	if !ok {
+		http.Error(w, "User data in session is of unexpected type", http.StatusInternalServerError)
+		return
+	}
+
+	var userMap map[string]interface{}
+	err = json.Unmarshal(userDataBytes, &userMap)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	logoutURL := fmt.Sprintf("%s/logout/%s", h.config.BaseURL, userMap["provider"])
+
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+    <title>Profile</title>
+    <style>
+        body { font-family: sans-serif; margin: 2em; background-color: #f4f4f9; color: #333; }
+        h1 { color: #0056b3; }
+        pre { background-color: #eef; padding: 1em; border-radius: 5px; border: 1px solid #ddd; white-space: pre-wrap; word-wrap: break-word; }
+        a { color: #007bff; text-decoration: none; }
+        a:hover { text-decoration: underline; }
+    </style>
+</head>
+<body>
+    <h1>Welcome, %s!</h1>
+    <p><a href="%s">Logout</a></p>
+    <h2>Your OIDC Data:</h2>
+    <pre>%s</pre>
+</body>
+</html>`, userMap["email"].(string), logoutURL, userMap)
+}
+
+// baggageUserIDKey is the OpenTelemetry baggage member carrying the
+// authenticated user's OIDC subject.
+const baggageUserIDKey = "user.id"
+
+// sessionExpired reports whether a session authenticated at the unix time
+// authAt is older than maxSessionAge. Sessions without auth_at predate it
+// and are expired too.
+func sessionExpired(authAt string, now time.Time, maxSessionAge time.Duration) bool {
+	seconds, err := strconv.ParseInt(authAt, 10, 64)
+	if err != nil {
+		return true
+	}
+	return time.Unix(seconds, 0).Before(now.Add(-maxSessionAge))
+}
+
+// OIDCMiddleware adds the subject of the logged in user, if any, to the
+// OpenTelemetry baggage of the request context. Sessions older than
+// APP_OIDC_MAX_SESSION_AGE are deleted and the request is rejected.
+func (h mainHandler) OIDCMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, err := h.store.Get(r, SessionName)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		userData, ok := session.Values["user"].([]byte)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var userMap map[string]string
+		if err := json.Unmarshal(userData, &userMap); err != nil || userMap["sub"] == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if sessionExpired(userMap["auth_at"], time.Now(), h.config.OIDCMaxSessionAge) {
+			session.Options.MaxAge = -1
+			if err := h.store.Save(r, w, session); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			http.Error(w, "Session expired, please log in again.", http.StatusUnauthorized)
+			return
+		}
+
+		member, err := baggage.NewMemberRaw(baggageUserIDKey, userMap["sub"])
+		if err != nil {
+			log.Printf("Invalid baggage member for user: %v", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		bag, err := baggage.New(member)
+		if err != nil {
+			log.Printf("Failed to create baggage: %v", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(baggage.ContextWithBaggage(r.Context(), bag)))
+	})
+}
+
+// baggageSpanProcessor copies the user ID from the baggage of the parent
+// context onto every span as it starts.
+type baggageSpanProcessor struct{}
+
+func (baggageSpanProcessor) OnStart(ctx context.Context, s sdktrace.ReadWriteSpan) {
+	userID := baggage.FromContext(ctx).Member(baggageUserIDKey).Value()
+	if userID == "" {
+		return
+	}
+	s.SetAttributes(attribute.String(baggageUserIDKey, userID))
+}
+
+func (baggageSpanProcessor) OnEnd(sdktrace.ReadOnlySpan)      {}
+func (baggageSpanProcessor) Shutdown(context.Context) error   { return nil }
+func (baggageSpanProcessor) ForceFlush(context.Context) error { return nil }
+
+var (
+	otelExportDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "otel_export_duration_seconds",
+		Help: "Duration of OTLP span export calls",
+	})
+	otelDroppedSpansTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "otel_dropped_spans_total",
+		Help: "No of spans dropped because the OTLP export failed",
+	})
+)
+
+// instrumentedExporter records the latency and failures of the wrapped
+// span exporter in Prometheus.
+type instrumentedExporter struct {
+	sdktrace.SpanExporter
+}
+
+func (e instrumentedExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	start := time.Now()
+	err := e.SpanExporter.ExportSpans(ctx, spans)
+	otelExportDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		otelDroppedSpansTotal.Add(float64(len(spans)))
+	}
+	return err
+}
+
+var tp *sdktrace.TracerProvider
+
+// initTracer creates and registers trace provider instance.
+func initTracer(ctx context.Context) error {
+	exp, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to initialize stdouttrace exporter: %w", err)
+	}
+	bsp := sdktrace.NewBatchSpanProcessor(instrumentedExporter{exp})
+	tp = sdktrace.NewTracerProvider(
+		sdktrace.WithSampler(sdktrace.AlwaysSample()),
+		sdktrace.WithSpanProcessor(baggageSpanProcessor{}),
+		sdktrace.WithSpanProcessor(bsp),
+	)
+	otel.SetTracerProvider(tp)
+	return nil
+}
+
+func (h *mainHandler) serveRabbitMQ(w http.ResponseWriter, r *http.Request) {
+	err := h.service.CheckRabbitMQStatus()
+	if err != nil {
+		log.Printf("RabbitMQ Error: %v", err)
+		http.Error(w, "RabbitMQ Connection Failure", http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, "RabbitMQ Connection SUCCESS")
+}
+
+// serveReadyz reports whether the configured backing services can serve
+// requests, answering 503 when any check fails. RabbitMQ is checked with the
+// management API aliveness test, which publishes and consumes a message,
+// and falls back to CheckRabbitMQStatus without a management API. A raised
+// memory or disk alarm also makes the app not ready.
+func (h *mainHandler) serveReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{}
+	ready := true
+	if h.service.RabbitMQURL != "" {
+		err := h.service.RabbitMQAliveCheck(r.Context())
+		if errors.Is(err, service.ErrRabbitMQManagementUnavailable) {
+			err = h.service.CheckRabbitMQStatus()
+		}
+		checks["rabbitmq"] = "ok"
+		if err != nil {
+			log.Printf("RabbitMQ readiness check failed: %v", err)
+			checks["rabbitmq"] = err.Error()
+			ready = false
+		}
+
+		// Publishes block for as long as an alarm is raised.
+		alarms, err := h.service.RabbitMQGetAlarmState(r.Context())
+		switch {
+		case errors.Is(err, service.ErrRabbitMQManagementUnavailable):
+		case err != nil:
+			log.Printf("RabbitMQ alarm check failed: %v", err)
+			checks["rabbitmq_alarms"] = err.Error()
+			ready = false
+		case alarms.Active():
+			checks["rabbitmq_alarms"] = fmt.Sprintf("memory alarm: %t, disk alarm: %t, blocked connections: %d",
+				alarms.MemoryAlarm, alarms.DiskAlarm, alarms.BlockedConnections)
+			ready = false
+		default:
+			checks["rabbitmq_alarms"] = "ok"
+		}
+	}
+
+	if !ready {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{"status": "not ready", "checks": checks})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"status": "ready", "checks": checks})
+}
+
+const (
+	// priorityQueue receives the messages RabbitMQSend publishes with a
+	// priority.
+	priorityQueue = "charm_priority"
+	// persistentQueue is the durable queue receiving the persistent
+	// messages of RabbitMQSend.
+	persistentQueue = "charm_durable"
+)
+
+func (h *mainHandler) RabbitMQSend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// An optional {"priority": n} body sends to the priorityQueue and
+	// {"persistent": true} to the persistentQueue instead; "charm" is
+	// declared transient and without x-max-priority. {"expiration_ms": n}
+	// sends to "charm" a message that expires after n milliseconds.
+	var request struct {
+		Priority     *int `json:"priority"`
+		Persistent   bool `json:"persistent"`
+		ExpirationMs int  `json:"expiration_ms"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	var err error
+	switch {
+	case request.ExpirationMs < 0:
+		http.Error(w, "expiration_ms must not be negative", http.StatusBadRequest)
+		return
+	case request.ExpirationMs > 0 && (request.Priority != nil || request.Persistent):
+		http.Error(w, "expiration_ms cannot be combined with priority or persistent", http.StatusBadRequest)
+		return
+	case request.Priority != nil && request.Persistent:
+		http.Error(w, "priority and persistent cannot be combined", http.StatusBadRequest)
+		return
+	case request.ExpirationMs > 0:
+		err = h.service.RabbitMQPublishWithExpiry(r.Context(), "charm", []byte("SUCCESS"), time.Duration(request.ExpirationMs)*time.Millisecond)
+	case request.Priority != nil:
+		if *request.Priority < 0 || *request.Priority > service.MaxPriority {
+			http.Error(w, fmt.Sprintf("priority must be between 0 and %d", service.MaxPriority), http.StatusBadRequest)
+			return
+		}
+		err = h.service.RabbitMQPublishWithPriority(r.Context(), priorityQueue, []byte("SUCCESS"), uint8(*request.Priority))
+	case request.Persistent:
+		err = h.service.RabbitMQPublishPersistent(r.Context(), persistentQueue, []byte("SUCCESS"))
+	default:
+		err = h.service.RabbitMQSend()
+	}
+	if err != nil {
+		log.Printf("Send error: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "FAIL")
+		return
+	}
+	fmt.Fprint(w, "SUCCESS")
+}
+
+// maxBatchSendMessages bounds the messages serveRabbitMQBatchSend publishes
+// in one request.
+const maxBatchSendMessages = 1000
+
+// serveRabbitMQBatchSend publishes all the messages of the request on one
+// confirm channel and reports how many the broker confirmed.
+func (h *mainHandler) serveRabbitMQBatchSend(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Queue    string   `json:"queue"`
+		Messages []string `json:"messages"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Queue == "" || len(request.Messages) == 0 {
+		http.Error(w, "Request body must be a JSON object with a queue and a non-empty messages array", http.StatusBadRequest)
+		return
+	}
+	if len(request.Messages) > maxBatchSendMessages {
+		http.Error(w, fmt.Sprintf("At most %d messages can be sent at once", maxBatchSendMessages), http.StatusBadRequest)
+		return
+	}
+	bodies := make([][]byte, 0, len(request.Messages))
+	for _, message := range request.Messages {
+		bodies = append(bodies, []byte(message))
+	}
+	results, err := h.service.RabbitMQPublishBatchConfirmed(r.Context(), request.Queue, bodies)
+	if errors.Is(err, service.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Batch send error: %v", err)
+		handleError(w, err)
+		return
+	}
+	published, failed := 0, 0
+	for _, err := range results {
+		if err != nil {
+			log.Printf("Batch send error: %v", err)
+			failed++
+			continue
+		}
+		published++
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"published": published, "errors": failed})
+}
+
+func (h *mainHandler) RabbitMQReceive(w http.ResponseWriter, r *http.Request) {
+	result, err := h.service.RabbitMQReceive()
+	if err != nil {
+		log.Printf("Receive error: %v", err)
+	}
+	fmt.Fprint(w, result)
+}
+
+func (h *mainHandler) RabbitMQSendHA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	unitStr := r.URL.Query().Get("unit")
+	if unitStr == "" {
+		http.Error(w, "Missing unit query parameter", http.StatusBadRequest)
+		return
+	}
+	unit, err := strconv.Atoi(unitStr)
+	if err != nil || unit < 0 {
+		http.Error(w, "Invalid unit query parameter", http.StatusBadRequest)
+		return
+	}
+
+	err = h.service.RabbitMQSendToUnit(unit)
+	if err != nil {
+		log.Printf("Send HA error: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "FAIL")
+		return
+	}
+	fmt.Fprint(w, "SUCCESS")
+}
+
+func (h *mainHandler) RabbitMQReceiveHA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	unitStr := r.URL.Query().Get("unit")
+	if unitStr == "" {
+		http.Error(w, "Missing unit query parameter", http.StatusBadRequest)
+		return
+	}
+	unit, err := strconv.Atoi(unitStr)
+	if err != nil || unit < 0 {
+		http.Error(w, "Invalid unit query parameter", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.service.RabbitMQReceiveFromUnit(unit)
+	if err != nil {
+		log.Printf("Receive HA error: %v", err)
+	}
+	fmt.Fprint(w, result)
+}
+
+func (h *mainHandler) serveRabbitMQStreamPublish(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	err = h.service.RabbitMQStreamPublish(r.Context(), r.PathValue("name"), body)
+	if errors.Is(err, service.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Stream publish error: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "FAIL")
+		return
+	}
+	fmt.Fprint(w, "SUCCESS")
+}
+
+// streamConsumeTimeout bounds how long serveRabbitMQStreamConsume waits for
+// the requested number of messages.
+const streamConsumeTimeout = 5 * time.Second
+
+// serveRabbitMQStreamConsume returns up to max messages of a stream, read
+// from the offset query parameter, as a JSON array of strings. It returns
+// what it read so far when the stream has no more messages within
+// streamConsumeTimeout. With a consumer query parameter the offset of the
+// last message returned is stored for that consumer, and reading resumes
+// after it when no offset is given.
+func (h *mainHandler) serveRabbitMQStreamConsume(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	max := 10
+	if v := query.Get("max"); v != "" {
+		var err error
+		if max, err = strconv.Atoi(v); err != nil || max <= 0 {
+			http.Error(w, "Invalid max query parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	offset := service.StreamOffsetStored
+	if v := query.Get("offset"); v != "" {
+		var err error
+		if offset, err = service.ParseStreamOffset(v); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), streamConsumeTimeout)
+	defer cancel()
+	// The handler runs on the stream client goroutine, which may still be
+	// in it when the timeout ends the consumption.
+	var mu sync.Mutex
+	messages := []string{}
+	err := h.service.RabbitMQStreamConsume(ctx, r.PathValue("name"), query.Get("consumer"), offset, func(body []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		messages = append(messages, string(body))
+		if len(messages) == max {
+			cancel()
+		}
+		return nil
+	})
+	if errors.Is(err, service.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil && !errors.Is(err, ctx.Err()) {
+		log.Printf("Stream consume error: %v", err)
+		handleError(w, err)
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	writeJSON(w, http.StatusOK, messages)
+}
+
+func (h *mainHandler) serveRabbitMQStreamStoreOffset(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Consumer string `json:"consumer"`
+		Offset   *int64 `json:"offset"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Consumer == "" || request.Offset == nil || *request.Offset < 0 {
+		http.Error(w, "Request body must be a JSON object with a consumer and a non-negative offset", http.StatusBadRequest)
+		return
+	}
+
+	err := h.service.RabbitMQStreamStoreOffset(r.Context(), r.PathValue("name"), request.Consumer, *request.Offset)
+	if err != nil {
+		log.Printf("Stream store offset error: %v", err)
+		handleError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *mainHandler) serveRabbitMQStreamQueryOffset(w http.ResponseWriter, r *http.Request) {
+	offset, err := h.service.RabbitMQStreamQueryOffset(r.Context(), r.PathValue("name"), r.PathValue("consumer"))
+	if errors.Is(err, service.ErrStreamOffsetNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Stream query offset error: %v", err)
+		handleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int64{"offset": offset})
+}
+
+func (h *mainHandler) serveRabbitMQDeleteQueue(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	ifUnused, ifEmpty := false, false
+	var err error
+	if v := query.Get("if_unused"); v != "" {
+		if ifUnused, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "Invalid if_unused query parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("if_empty"); v != "" {
+		if ifEmpty, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "Invalid if_empty query parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	purged, err := h.service.RabbitMQDeleteQueue(r.Context(), r.PathValue("name"), ifUnused, ifEmpty)
+	if errors.Is(err, service.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, service.ErrQueueConflict) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Delete queue error: %v", err)
+		handleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"purged": purged})
+}
+
+func (h *mainHandler) serveRabbitMQDeleteExchange(w http.ResponseWriter, r *http.Request) {
+	ifUnused := false
+	if v := r.URL.Query().Get("if_unused"); v != "" {
+		var err error
+		if ifUnused, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "Invalid if_unused query parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	err := h.service.RabbitMQDeleteExchange(r.Context(), r.PathValue("name"), ifUnused)
+	if errors.Is(err, service.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, service.ErrExchangeInUse) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Delete exchange error: %v", err)
+		handleError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *mainHandler) serveRabbitMQGetMessage(w http.ResponseWriter, r *http.Request) {
+	ack := false
+	if v := r.URL.Query().Get("ack"); v != "" {
+		var err error
+		if ack, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "Invalid ack query parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	msg, err := h.service.RabbitMQGetMessage(r.Context(), r.PathValue("name"), ack)
+	switch {
+	case errors.Is(err, service.ErrInvalidName):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, service.ErrNoMessage):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		log.Printf("Get message error: %v", err)
+		handleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, msg)
+}
+
+func (h *mainHandler) serveRabbitMQMessageRate(w http.ResponseWriter, r *http.Request) {
+	messageRate, err := h.service.RabbitMQMessageRate(r.Context(), r.PathValue("name"))
+	if errors.Is(err, service.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		handleRabbitMQManagementError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, messageRate)
+}
+
+func (h *mainHandler) serveRabbitMQAlarms(w http.ResponseWriter, r *http.Request) {
+	alarms, err := h.service.RabbitMQGetAlarmState(r.Context())
+	if err != nil {
+		handleRabbitMQManagementError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, alarms)
+}
+
+func (h *mainHandler) serveRabbitMQConsumerGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := h.service.RabbitMQConsumerGroups(r.Context())
+	if err != nil {
+		handleRabbitMQManagementError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]map[string]service.ConsumerGroup{"groups": groups})
+}
+
+func (h *mainHandler) serveRabbitMQMirrorSync(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Action string `json:"action"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || (request.Action != "pause" && request.Action != "resume") {
+		http.Error(w, `Request body must be {"action":"pause"} or {"action":"resume"}`, http.StatusBadRequest)
+		return
+	}
+
+	err := h.service.RabbitMQPauseMirrorSync(r.Context(), r.PathValue("name"), request.Action == "pause")
+	if errors.Is(err, service.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		handleRabbitMQManagementError(w, err)
+		return
 	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	_, err = m.Write([]byte(message))
+func (h *mainHandler) serveRabbitMQShovelStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := h.service.RabbitMQShovelStatus(r.Context(), r.PathValue("name"))
 	if err != nil {
-		handleError(w, err)
+		handleRabbitMQManagementError(w, err)
+		return
 	}
+	writeJSON(w, http.StatusOK, status)
+}
 
-	err = m.Close()
+func (h *mainHandler) serveRabbitMQNodeInfo(w http.ResponseWriter, r *http.Request) {
+	node, err := h.service.RabbitMQGetNodeInfo(r.Context(), r.PathValue("name"))
 	if err != nil {
-		handleError(w, err)
+		handleRabbitMQManagementError(w, err)
+		return
 	}
-
-	fmt.Fprintf(w, "Sent")
+	writeJSON(w, http.StatusOK, node)
 }
 
-func (h mainHandler) serveUserDefinedConfig(w http.ResponseWriter, r *http.Request) {
-	h.counter.Inc()
-
-	w.Header().Set("Content-Type", "application/json")
-
-	user_defined_config, found := os.LookupEnv("APP_USER_DEFINED_CONFIG")
-	if !found {
-		json.NewEncoder(w).Encode(nil)
+func (h *mainHandler) serveRabbitMQListNodes(w http.ResponseWriter, r *http.Request) {
+	nodes, err := h.service.RabbitMQListNodes(r.Context())
+	if err != nil {
+		handleRabbitMQManagementError(w, err)
 		return
 	}
-	json.NewEncoder(w).Encode(user_defined_config)
+	writeJSON(w, http.StatusOK, map[string][]service.NodeInfo{"nodes": nodes})
 }
 
-func (h mainHandler) servePostgresql(w http.ResponseWriter, r *http.Request) {
-	err := h.service.CheckPostgresqlMigrateStatus()
+// serveRabbitMQVersion summarises the broker: its version, the cluster name
+// and the number of nodes.
+func (h *mainHandler) serveRabbitMQVersion(w http.ResponseWriter, r *http.Request) {
+	version, err := h.service.RabbitMQGetVersion(r.Context())
 	if err != nil {
-		log.Printf(err.Error())
-		io.WriteString(w, "FAILURE")
+		handleRabbitMQManagementError(w, err)
+		return
+	}
+	clusterName, err := h.service.RabbitMQGetClusterName(r.Context())
+	if err != nil {
+		handleRabbitMQManagementError(w, err)
+		return
+	}
+	nodes, err := h.service.RabbitMQListNodes(r.Context())
+	if err != nil {
+		handleRabbitMQManagementError(w, err)
 		return
-	} else {
-		io.WriteString(w, "SUCCESS")
 	}
+	writeJSON(w, http.StatusOK, map[string]any{"version": version, "cluster_name": clusterName, "nodes": len(nodes)})
 }
 
-// OIDC-specific: callback handler now shows the user data directly.
-func (h mainHandler) serveAuthCallback(w http.ResponseWriter, r *http.Request) {
-	user, err := gothic.CompleteUserAuth(w, r)
+func (h *mainHandler) serveRabbitMQPermissions(w http.ResponseWriter, r *http.Request) {
+	permissions, err := h.service.RabbitMQGetVhostPermissions(r.Context(), r.PathValue("user"))
+	var apiErr *service.ManagementAPIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "user not found"})
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		handleRabbitMQManagementError(w, err)
 		return
 	}
+	writeJSON(w, http.StatusOK, permissions)
+}
 
-	session, err := h.store.New(r, SessionName)
+func (h *mainHandler) serveRabbitMQListConsumers(w http.ResponseWriter, r *http.Request) {
+	consumers, err := h.service.RabbitMQListConsumers(r.Context())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		handleRabbitMQManagementError(w, err)
 		return
 	}
+	writeJSON(w, http.StatusOK, map[string][]service.ConsumerInfo{"consumers": consumers})
+}
 
-	userMap := map[string]string{
-		"email":    user.Email,
-		"provider": user.Provider,
+func (h *mainHandler) serveRabbitMQDeclareExclusiveQueue(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Name string `json:"name"`
 	}
-
-	userData, err := json.Marshal(userMap)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Name == "" {
+		http.Error(w, "Request body must be a JSON object with a name", http.StatusBadRequest)
 		return
 	}
 
-	session.Values["user"] = userData
-	err = h.store.Save(r, w, session)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	err := h.service.RabbitMQDeclareExclusiveQueue(r.Context(), request.Name)
+	switch {
+	case errors.Is(err, service.ErrInvalidName):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, service.ErrQueueConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("Declare exclusive queue error: %v", err)
+		handleError(w, err)
 		return
 	}
+	w.WriteHeader(http.StatusCreated)
+}
 
-	profileURL := h.config.BaseURL + "/profile"
-	w.Header().Set("Location", profileURL)
-	w.WriteHeader(http.StatusTemporaryRedirect)
+// validConsumerPriority reports whether priority fits the x-priority
+// consumer argument.
+func validConsumerPriority(priority int) bool {
+	return priority >= math.MinInt32 && priority <= math.MaxInt32
 }
 
-// OIDC-specific: logout handler
-func (h mainHandler) serveLogout(w http.ResponseWriter, r *http.Request) {
-	gothic.Logout(w, r)
-	session, err := h.store.New(r, SessionName)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+// serveRabbitMQStartPriorityConsumer starts a background consumer of the
+// queue with the requested priority, which logs and acknowledges every
+// delivery, and returns its tag.
+func (h *mainHandler) serveRabbitMQStartPriorityConsumer(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Queue    string `json:"queue"`
+		Priority int    `json:"priority"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Queue == "" || !validConsumerPriority(request.Priority) {
+		http.Error(w, "Request body must be a JSON object with a queue and a 32-bit priority", http.StatusBadRequest)
 		return
 	}
 
-	// Set MaxAge to -1. This effectively deletes the cookie.
-	session.Options.MaxAge = -1
-	err = h.store.Save(r, w, session)
+	consumer, err := h.service.RabbitMQStartPriorityConsumer(request.Queue, request.Priority, func(delivery amqp.Delivery) error {
+		log.Printf("Priority consumer received %d bytes from %s", len(delivery.Body), request.Queue)
+		return nil
+	})
+	if errors.Is(err, service.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Printf("Start priority consumer error: %v", err)
+		handleError(w, err)
 		return
 	}
+	writeJSON(w, http.StatusCreated, consumer)
+}
 
-	homeURL := h.config.BaseURL
-	if homeURL == "" {
-		homeURL = "/"
+// serveRabbitMQConsumerPriority changes the priority of a consumer started
+// by serveRabbitMQStartPriorityConsumer by cancelling and re-registering it.
+func (h *mainHandler) serveRabbitMQConsumerPriority(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Priority *int `json:"priority"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Priority == nil || !validConsumerPriority(*request.Priority) {
+		http.Error(w, "Request body must be a JSON object with a 32-bit priority", http.StatusBadRequest)
+		return
 	}
-	w.Header().Set("Location", homeURL)
-	w.WriteHeader(http.StatusTemporaryRedirect)
-}
 
-func (h mainHandler) serveProfile(w http.ResponseWriter, r *http.Request) {
-	session, err := h.store.Get(r, SessionName)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	err := h.service.RabbitMQSetConsumerPriority(r.Context(), r.PathValue("tag"), *request.Priority)
+	if errors.Is(err, service.ErrConsumerNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
-	userData, ok := session.Values["user"]
-	if !ok {
-		http.Error(w, "User not authenticated.", http.StatusForbidden)
+	if err != nil {
+		log.Printf("Consumer priority error: %v", err)
+		handleError(w, err)
 		return
 	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	userDataBytes, ok := userData.([]byte)
-	if !ok {
-		http.Error(w, "User data in session is of unexpected type", http.StatusInternalServerError)
+func (h *mainHandler) serveRabbitMQCancelConsumer(w http.ResponseWriter, r *http.Request) {
+	err := h.service.RabbitMQCancelConsumer(r.Context(), r.PathValue("tag"))
+	if errors.Is(err, service.ErrConsumerNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
-
-	var userMap map[string]interface{}
-	err = json.Unmarshal(userDataBytes, &userMap)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Printf("Cancel consumer error: %v", err)
+		handleError(w, err)
 		return
 	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	logoutURL := fmt.Sprintf("%s/logout/%s", h.config.BaseURL, userMap["provider"])
+func (h *mainHandler) serveRabbitMQPublishExchange(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		RoutingKey  string `json:"routing_key"`
+		Body        string `json:"body"`
+		ContentType string `json:"content_type"`
+		Persistent  bool   `json:"persistent"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Request body must be a JSON object", http.StatusBadRequest)
+		return
+	}
 
-	fmt.Fprintf(w, `<!DOCTYPE html>
-<html>
-<head>
-    <title>Profile</title>
-    <style>
-        body { font-family: sans-serif; margin: 2em; background-color: #f4f4f9; color: #333; }
-        h1 { color: #0056b3; }
-        pre { background-color: #eef; padding: 1em; border-radius: 5px; border: 1px solid #ddd; white-space: pre-wrap; word-wrap: break-word; }
-        a { color: #007bff; text-decoration: none; }
-        a:hover { text-decoration: underline; }
-    </style>
-</head>
-<body>
-    <h1>Welcome, %s!</h1>
-    <p><a href="%s">Logout</a></p>
-    <h2>Your OIDC Data:</h2>
-    <pre>%s</pre>
-</body>
-</html>`, userMap["email"].(string), logoutURL, userMap)
+	err := h.service.RabbitMQPublishToExchange(r.Context(), r.PathValue("name"), request.RoutingKey, service.RabbitMQMessage{
+		Body:        []byte(request.Body),
+		ContentType: request.ContentType,
+		Persistent:  request.Persistent,
+	})
+	switch {
+	case errors.Is(err, service.ErrInvalidName):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, service.ErrExchangeNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		log.Printf("Publish to exchange error: %v", err)
+		handleError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
 }
 
-var tp *sdktrace.TracerProvider
+func (h *mainHandler) serveRabbitMQReprocess(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Source      string `json:"source"`
+		Destination string `json:"destination"`
+		Max         int    `json:"max"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Source == "" || request.Destination == "" || request.Max <= 0 {
+		http.Error(w, "Request body must be a JSON object with a source, destination and positive max", http.StatusBadRequest)
+		return
+	}
 
-// initTracer creates and registers trace provider instance.
-func initTracer(ctx context.Context) error {
-	exp, err := otlptracehttp.New(ctx)
+	moved, err := h.service.RabbitMQPurgeAndRepublish(r.Context(), request.Source, request.Destination, request.Max)
+	if errors.Is(err, service.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, service.ErrQueueNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		return fmt.Errorf("failed to initialize stdouttrace exporter: %w", err)
+		log.Printf("Reprocess error after moving %d messages: %v", moved, err)
+		handleError(w, err)
+		return
 	}
-	bsp := sdktrace.NewBatchSpanProcessor(exp)
-	tp = sdktrace.NewTracerProvider(
-		sdktrace.WithSampler(sdktrace.AlwaysSample()),
-		sdktrace.WithSpanProcessor(bsp),
-	)
-	otel.SetTracerProvider(tp)
-	return nil
+	writeJSON(w, http.StatusOK, map[string]int{"moved": moved})
 }
 
-func (h *mainHandler) serveRabbitMQ(w http.ResponseWriter, r *http.Request) {
-	err := h.service.CheckRabbitMQStatus()
-	if err != nil {
-		log.Printf("RabbitMQ Error: %v", err)
-		http.Error(w, "RabbitMQ Connection Failure", http.StatusInternalServerError)
+// serveRabbitMQNackAll fetches up to RabbitMQNackAllMax ready messages of
+// the queue with basic.get, leaving them unacked, and then nacks them in one
+// go, so with requeue=false that part of the backlog is dropped or
+// dead-lettered. The response reports how many are left.
+func (h *mainHandler) serveRabbitMQNackAll(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Queue   string `json:"queue"`
+		Requeue bool   `json:"requeue"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Queue == "" {
+		http.Error(w, "Request body must be a JSON object with a queue", http.StatusBadRequest)
 		return
 	}
-	fmt.Fprintf(w, "RabbitMQ Connection SUCCESS")
-}
 
-func (h *mainHandler) RabbitMQSend(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	nacked, remaining, err := h.service.RabbitMQNackAll(r.Context(), request.Queue, request.Requeue, h.config.RabbitMQNackAllMax)
+	if errors.Is(err, service.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	err := h.service.RabbitMQSend()
 	if err != nil {
-		log.Printf("Send error: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, "FAIL")
+		log.Printf("Nack all error: %v", err)
+		handleError(w, err)
 		return
 	}
-	fmt.Fprint(w, "SUCCESS")
+	writeJSON(w, http.StatusOK, map[string]int{"nacked": nacked, "remaining": remaining})
 }
 
-func (h *mainHandler) RabbitMQReceive(w http.ResponseWriter, r *http.Request) {
-	result, err := h.service.RabbitMQReceive()
-	if err != nil {
-		log.Printf("Receive error: %v", err)
+var rabbitmqClearAllRejectedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "rabbitmq_clear_all_rejected_total",
+	Help: "No of RabbitMQ clear-all requests rejected for a missing or invalid confirmation token",
+})
+
+func (h *mainHandler) serveRabbitMQClearAll(w http.ResponseWriter, r *http.Request) {
+	purged, err := h.service.RabbitMQClearAllQueues(r.Context())
+	switch {
+	case errors.Is(err, service.ErrPartialPurge):
+		log.Printf("Clear all queues error: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"purged": purged, "error": err.Error()})
+	case err != nil:
+		handleRabbitMQManagementError(w, err)
+	default:
+		writeJSON(w, http.StatusOK, map[string]any{"purged": purged})
 	}
-	fmt.Fprint(w, result)
 }
 
-func (h *mainHandler) RabbitMQSendHA(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost && r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-	unitStr := r.URL.Query().Get("unit")
-	if unitStr == "" {
-		http.Error(w, "Missing unit query parameter", http.StatusBadRequest)
+// handleRabbitMQManagementError maps management API errors to HTTP responses.
+func handleRabbitMQManagementError(w http.ResponseWriter, err error) {
+	if errors.Is(err, service.ErrRabbitMQManagementUnavailable) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
 		return
 	}
-	unit, err := strconv.Atoi(unitStr)
-	if err != nil || unit < 0 {
-		http.Error(w, "Invalid unit query parameter", http.StatusBadRequest)
+	var apiErr *service.ManagementAPIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		http.Error(w, "Not found", http.StatusNotFound)
 		return
 	}
+	log.Printf("RabbitMQ management API error: %v", err)
+	handleError(w, err)
+}
 
-	err = h.service.RabbitMQSendToUnit(unit)
+func (h *mainHandler) serveRabbitMQListQueues(w http.ResponseWriter, r *http.Request) {
+	queues, err := h.service.RabbitMQListQueues(r.Context())
 	if err != nil {
-		log.Printf("Send HA error: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, "FAIL")
+		handleRabbitMQManagementError(w, err)
 		return
 	}
-	fmt.Fprint(w, "SUCCESS")
+	writeJSON(w, http.StatusOK, map[string][]string{"queues": queues})
 }
 
-func (h *mainHandler) RabbitMQReceiveHA(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+func (h *mainHandler) serveRabbitMQListBindings(w http.ResponseWriter, r *http.Request) {
+	bindings, err := h.service.RabbitMQListBindings(r.Context(), r.PathValue("name"))
+	if errors.Is(err, service.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	unitStr := r.URL.Query().Get("unit")
-	if unitStr == "" {
-		http.Error(w, "Missing unit query parameter", http.StatusBadRequest)
+	if err != nil {
+		handleRabbitMQManagementError(w, err)
 		return
 	}
-	unit, err := strconv.Atoi(unitStr)
-	if err != nil || unit < 0 {
-		http.Error(w, "Invalid unit query parameter", http.StatusBadRequest)
-		return
+	writeJSON(w, http.StatusOK, map[string][]service.BindingInfo{"bindings": bindings})
+}
+
+var (
+	rabbitmqConnectionUp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "rabbitmq_connection_up",
+		Help: "Whether the shared RabbitMQ connection is open (1) or closed (0)",
+	})
+	rabbitmqChannelCount = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "rabbitmq_channel_count",
+		Help: "No of channels the broker counts on the shared RabbitMQ connection",
+	})
+	rabbitmqDeadLetterQueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rabbitmq_dead_letter_queue_depth",
+		Help: "No of messages in a RabbitMQ dead-letter queue",
+	}, []string{"queue"})
+)
+
+// reportRabbitMQMetrics refreshes the RabbitMQ connection and dead-letter
+// queue gauges every interval until ctx is done, logging a warning for
+// dead-letter queues deeper than a positive dlqAlertThreshold.
+func reportRabbitMQMetrics(ctx context.Context, svc *service.Service, interval time.Duration, dlqAlertThreshold int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		up, channels, err := svc.RabbitMQConnectionMetrics(ctx)
+		if up {
+			rabbitmqConnectionUp.Set(1)
+		} else {
+			rabbitmqConnectionUp.Set(0)
+		}
+		switch {
+		case err == nil:
+			rabbitmqChannelCount.Set(float64(channels))
+		case !errors.Is(err, service.ErrRabbitMQManagementUnavailable) && ctx.Err() == nil:
+			log.Printf("RabbitMQ channel count error: %v", err)
+		}
+
+		dlqs, err := svc.RabbitMQDeadLetterStats(ctx)
+		switch {
+		case err == nil:
+			// Drop queues that no longer exist.
+			rabbitmqDeadLetterQueueDepth.Reset()
+			for _, dlq := range dlqs {
+				rabbitmqDeadLetterQueueDepth.WithLabelValues(dlq.Queue).Set(float64(dlq.Messages))
+				if dlqAlertThreshold > 0 && dlq.Messages > dlqAlertThreshold {
+					log.Printf("WARNING: dead-letter queue %s holds %d messages, above APP_RABBITMQ_DLQ_ALERT_THRESHOLD %d",
+						dlq.Queue, dlq.Messages, dlqAlertThreshold)
+				}
+			}
+		case !errors.Is(err, service.ErrRabbitMQManagementUnavailable) && ctx.Err() == nil:
+			log.Printf("RabbitMQ dead-letter stats error: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 	}
+}
 
-	result, err := h.service.RabbitMQReceiveFromUnit(unit)
-	if err != nil {
-		log.Printf("Receive HA error: %v", err)
+// relayOutbox publishes the messages scheduled in the outbox every interval
+// until ctx is done, creating the outbox table first.
+func relayOutbox(ctx context.Context, svc *service.Service, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	created := false
+	for {
+		var err error
+		if !created {
+			err = svc.PostgresqlCreateOutbox(ctx)
+			created = err == nil
+		}
+		if created {
+			_, err = svc.RabbitMQRelayOutbox(ctx)
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Outbox relay error: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 	}
-	fmt.Fprint(w, result)
 }
 
 func main() {
+	// Report every invalid variable at once rather than the first one.
+	if errs := ValidateConfig(); len(errs) > 0 {
+		for _, err := range errs {
+			log.Printf("Configuration error: %v", err)
+		}
+		os.Exit(1)
+	}
+
 	// Load all configuration from environment variables at startup.
 	config, err := NewConfig()
 	if err != nil {
@@ -476,12 +2969,8 @@ func main() {
 	}
 
 	// OIDC-specific: setup gothic session store
-	key, found := os.LookupEnv("APP_SECRET_KEY")
-	if !found || key == "" {
-		log.Fatal("APP_SECRET_KEY environment variable must be set.")
-	}
-	store := sessions.NewCookieStore([]byte(key))
-	store.MaxAge(maxAge)
+	store := sessions.NewCookieStore([]byte(os.Getenv("APP_SECRET_KEY")))
+	store.MaxAge(int(config.OIDCMaxSessionAge.Seconds()))
 	store.Options.Path = config.BasePath
 	store.Options.HttpOnly = true
 	gothic.Store = store
@@ -544,28 +3033,166 @@ func main() {
 	postgresqlURL := os.Getenv("POSTGRESQL_DB_CONNECT_STRING")
 	rabbitmqURL := os.Getenv("RABBITMQ_CONNECT_STRING")
 	rabbitmqURLS := strings.Split(os.Getenv("RABBITMQ_CONNECT_STRINGS"), ",")
+	rabbitmqConfig, err := service.NewRabbitMQConfig(rabbitmqURL)
+	if err != nil {
+		log.Fatalf("Invalid RABBITMQ_CONNECT_STRING: %v", err)
+	}
+	// The management API is not always reachable on the AMQP host, for
+	// example behind an ingress.
+	if managementURL := os.Getenv("APP_RABBITMQ_MANAGEMENT_URL"); managementURL != "" {
+		rabbitmqConfig.ManagementURL = strings.TrimSuffix(managementURL, "/")
+	}
+
+	svc := &service.Service{
+		PostgresqlURL:              postgresqlURL,
+		PostgresqlStatementTimeout: config.DBStatementTimeout,
+
+		RabbitMQURL:  rabbitmqURL,
+		RabbitMQURLS: rabbitmqURLS,
+		RabbitMQ:     rabbitmqConfig,
+
+		RabbitMQDLQSuffixes: config.RabbitMQDLQSuffixes,
+
+		RabbitMQStreamHost:     os.Getenv("RABBITMQ_STREAM_HOST"),
+		RabbitMQStreamPort:     config.RabbitMQStreamPort,
+		RabbitMQStreamUser:     os.Getenv("RABBITMQ_STREAM_USER"),
+		RabbitMQStreamPassword: os.Getenv("RABBITMQ_STREAM_PASSWORD"),
+
+		FGAAPIURL:  os.Getenv("FGA_HTTP_API_URL"),
+		FGAStoreID: os.Getenv("FGA_STORE_ID"),
+		FGAToken:   os.Getenv("FGA_TOKEN"),
+
+		FGARetryMaxAttempts: config.FGARetryMaxAttempts,
+		FGAOAuth2TokenURL:   os.Getenv("APP_FGA_OAUTH2_TOKEN_URL"),
+		FGAClientID:         os.Getenv("APP_FGA_CLIENT_ID"),
+		FGAClientSecret:     os.Getenv("APP_FGA_CLIENT_SECRET"),
+		FGAAudience:         os.Getenv("APP_FGA_AUDIENCE"),
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	svc.ManagedConnection = amqputil.NewManagedConnection("go-app@"+hostname, svc.DialRabbitMQ, config.RabbitMQChannelPoolSize)
+	confirmToken, err := newConfirmToken()
+	if err != nil {
+		log.Fatalf("Failed to generate confirmation token: %v", err)
+	}
+	if config.EnableDebugEndpoints {
+		log.Printf("INFO: X-Confirm-Token for destructive debug endpoints: %s", confirmToken)
+	}
 
-	mux := http.NewServeMux()
 	mainHandler := mainHandler{
 		counter: requestCounter,
-		service: service.Service{
-			PostgresqlURL: postgresqlURL,
-			RabbitMQURL:   rabbitmqURL,
-			RabbitMQURLS:  rabbitmqURLS,
-		},
-		config: config,
-		store:  store,
+		service: svc,
+		config:  config,
+		store:   store,
+
+		confirmToken: confirmToken,
+	}
+
+	if postgresqlURL != "" {
+		waitCtx, waitCancel := context.WithTimeout(ctx, config.DBWaitTimeout)
+		err := svc.PostgresqlWaitForReady(waitCtx, time.Second)
+		waitCancel()
+		if err != nil {
+			log.Fatalf("PostgreSQL not ready after %s: %v", config.DBWaitTimeout, err)
+		}
+		log.Println("PostgreSQL is ready")
 	}
+
+	mux := http.NewServeMux()
 	mux.HandleFunc("/{$}", mainHandler.serveHelloWorld)
+	mux.HandleFunc("GET /readyz", mainHandler.serveReadyz)
 	mux.HandleFunc("/send_mail", mainHandler.serveMail)
 	mux.HandleFunc("/openfga/list-authorization-models", mainHandler.serveOpenFgaListAuthorizationModels)
+	mux.HandleFunc("GET /openfga/models", mainHandler.serveOpenFgaListModelsByType)
+	mux.HandleFunc("/openfga/assertions/{model_id}", mainHandler.serveOpenFgaRunAssertions)
+	mux.HandleFunc("GET /openfga/model/{id}", mainHandler.serveOpenFgaGetModel)
+	mux.HandleFunc("GET /openfga/model/diff", mainHandler.serveOpenFgaModelDiff)
+	mux.HandleFunc("POST /openfga/model", mainHandler.debugOnly(mainHandler.serveOpenFgaMigrateModel))
+	mux.HandleFunc("POST /openfga/reindex", mainHandler.serveOpenFgaReindex)
+	mux.HandleFunc("GET /openfga/tuple-changes", mainHandler.serveOpenFGATupleChanges)
+	mux.HandleFunc("POST /openfga/import", mainHandler.debugOnly(mainHandler.serveOpenFgaImportTuples))
+	mux.HandleFunc("GET /openfga/stores", mainHandler.serveOpenFgaListStores)
+	mux.HandleFunc("POST /openfga/store", mainHandler.debugOnly(mainHandler.serveOpenFgaCreateStore))
+	mux.HandleFunc("DELETE /openfga/store/{id}", mainHandler.debugOnly(mainHandler.serveOpenFgaDeleteStore))
+	// Counting pages through every tuple, keep it from hammering OpenFGA.
+	mux.HandleFunc("GET /openfga/tuples/count", rateLimited(config.FGACountRateLimit, mainHandler.serveOpenFgaTupleCount))
+	mux.HandleFunc("GET /openfga/tuples/search", mainHandler.serveOpenFgaTupleSearch)
+	mux.HandleFunc("GET /openfga/users", mainHandler.serveOpenFgaListUsers)
+	mux.HandleFunc("POST /openfga/benchmark", mainHandler.debugOnly(mainHandler.serveOpenFgaBenchmark))
 	mux.HandleFunc("/env/user-defined-config", mainHandler.serveUserDefinedConfig)
 	mux.HandleFunc("/postgresql/migratestatus", mainHandler.servePostgresql)
+	mux.HandleFunc("GET /postgresql/tables/{table}/indexes", mainHandler.servePostgresqlIndexes)
+	mux.HandleFunc("GET /postgresql/tables/{table}/constraints", mainHandler.servePostgresqlConstraints)
+	mux.HandleFunc("GET /postgresql/tables/{table}/partitions", mainHandler.servePostgresqlPartitions)
+	mux.HandleFunc("GET /postgresql/tables/{table}/count", mainHandler.servePostgresqlRowCount)
+	mux.HandleFunc("GET /postgresql/pool-stats", mainHandler.servePostgresqlPoolStats)
+	mux.HandleFunc("GET /postgresql/sequences", mainHandler.servePostgresqlSequences)
+	mux.HandleFunc("GET /postgresql/table-sizes", mainHandler.servePostgresqlTableSizes)
+	mux.HandleFunc("GET /postgresql/table-stats", mainHandler.servePostgresqlTableStats)
+	mux.HandleFunc("GET /postgresql/wal-status", mainHandler.servePostgresqlWalStatus)
+	mux.HandleFunc("GET /postgresql/ssl-status", mainHandler.servePostgresqlSSLStatus)
+	mux.HandleFunc("GET /postgresql/extensions", mainHandler.servePostgresqlExtensions)
+	mux.HandleFunc("POST /postgresql/extensions/{name}", mainHandler.debugOnly(mainHandler.servePostgresqlEnableExtension))
+	mux.HandleFunc("DELETE /postgresql/extensions/{name}", mainHandler.debugOnly(mainHandler.servePostgresqlDisableExtension))
+	mux.HandleFunc("GET /postgresql/active-queries", mainHandler.servePostgresqlActiveQueries)
+	mux.HandleFunc("GET /postgresql/prepared-statements", mainHandler.debugOnly(mainHandler.servePostgresqlPreparedStatements))
+	mux.HandleFunc("GET /postgresql/slow-queries", mainHandler.servePostgresqlSlowQueries)
+	mux.HandleFunc("GET /postgresql/locks", mainHandler.debugOnly(mainHandler.servePostgresqlLocks))
+	mux.HandleFunc("POST /postgresql/index", mainHandler.debugOnly(mainHandler.servePostgresqlCreateIndex))
+	mux.HandleFunc("POST /postgresql/transaction", mainHandler.debugOnly(mainHandler.servePostgresqlTransaction))
+	mux.HandleFunc("POST /postgresql/tables/{table}/notifier", mainHandler.debugOnly(mainHandler.servePostgresqlInstallNotifier))
+	mux.HandleFunc("DELETE /postgresql/tables/{table}/notifier", mainHandler.debugOnly(mainHandler.servePostgresqlRemoveNotifier))
+	mux.HandleFunc("POST /postgresql/audit-trigger/{table}", mainHandler.debugOnly(mainHandler.servePostgresqlCreateAuditTrigger))
+	mux.HandleFunc("DELETE /postgresql/audit-trigger/{table}", mainHandler.debugOnly(mainHandler.servePostgresqlDropAuditTrigger))
+	mux.HandleFunc("GET /postgresql/notify/{channel}", mainHandler.debugOnly(mainHandler.servePostgresqlNotify))
+	mux.HandleFunc("GET /postgresql/export", mainHandler.debugOnly(mainHandler.servePostgresqlExportCSV))
+	mux.HandleFunc("POST /postgresql/tables/{table}/rows", mainHandler.debugOnly(mainHandler.servePostgresqlBulkInsert))
+	mux.HandleFunc("POST /postgresql/query", mainHandler.debugOnly(mainHandler.servePostgresqlQuery))
+	mux.HandleFunc("POST /postgresql/schema/{name}/query", mainHandler.debugOnly(mainHandler.servePostgresqlQueryInSchema))
+	mux.HandleFunc("POST /postgresql/role", mainHandler.debugOnly(mainHandler.servePostgresqlCreateRole))
+	mux.HandleFunc("POST /postgresql/grant", mainHandler.debugOnly(mainHandler.servePostgresqlGrant))
+	mux.HandleFunc("POST /postgresql/cancel-query/{pid}", mainHandler.debugOnly(mainHandler.servePostgresqlCancelQuery))
+	mux.HandleFunc("POST /postgresql/kill-idle", mainHandler.debugOnly(mainHandler.servePostgresqlKillIdle))
+	mux.HandleFunc("POST /postgresql/analyze", mainHandler.debugOnly(mainHandler.servePostgresqlAnalyze))
+	mux.HandleFunc("POST /postgresql/hypertable", mainHandler.debugOnly(mainHandler.servePostgresqlCreateHypertable))
+	mux.HandleFunc("POST /postgresql/vacuum-full", mainHandler.debugOnly(
+		mainHandler.requireConfirmToken(vacuumFullRejectedTotal, mainHandler.servePostgresqlVacuumFull)))
 	mux.HandleFunc("/rabbitmq/status", mainHandler.serveRabbitMQ) // New route
 	mux.HandleFunc("/rabbitmq/send", mainHandler.RabbitMQSend)
+	mux.HandleFunc("POST /rabbitmq/batch-send", mainHandler.debugOnly(mainHandler.serveRabbitMQBatchSend))
 	mux.HandleFunc("/rabbitmq/receive", mainHandler.RabbitMQReceive)
 	mux.HandleFunc("/rabbitmq/send_ha", mainHandler.RabbitMQSendHA)
 	mux.HandleFunc("/rabbitmq/receive_ha", mainHandler.RabbitMQReceiveHA)
+	mux.HandleFunc("/rabbitmq/stream/{name}", mainHandler.serveRabbitMQStreamPublish)
+	mux.HandleFunc("POST /rabbitmq/stream/{name}/consume", mainHandler.serveRabbitMQStreamConsume)
+	mux.HandleFunc("POST /rabbitmq/stream/{name}/offset", mainHandler.serveRabbitMQStreamStoreOffset)
+	mux.HandleFunc("GET /rabbitmq/stream/{name}/offset/{consumer}", mainHandler.serveRabbitMQStreamQueryOffset)
+	mux.HandleFunc("GET /rabbitmq/queues", mainHandler.serveRabbitMQListQueues)
+	mux.HandleFunc("POST /rabbitmq/queue/exclusive", mainHandler.debugOnly(mainHandler.serveRabbitMQDeclareExclusiveQueue))
+	mux.HandleFunc("DELETE /rabbitmq/queue/{name}", mainHandler.debugOnly(mainHandler.serveRabbitMQDeleteQueue))
+	mux.HandleFunc("GET /rabbitmq/queue/{name}/message", mainHandler.serveRabbitMQGetMessage)
+	mux.HandleFunc("GET /rabbitmq/queue/{name}/bindings", mainHandler.serveRabbitMQListBindings)
+	mux.HandleFunc("GET /rabbitmq/consumers", mainHandler.serveRabbitMQListConsumers)
+	mux.HandleFunc("POST /rabbitmq/consumer", mainHandler.debugOnly(mainHandler.serveRabbitMQStartPriorityConsumer))
+	mux.HandleFunc("PUT /rabbitmq/consumer/{tag}/priority", mainHandler.debugOnly(mainHandler.serveRabbitMQConsumerPriority))
+	mux.HandleFunc("DELETE /rabbitmq/consumer/{tag}", mainHandler.debugOnly(mainHandler.serveRabbitMQCancelConsumer))
+	mux.HandleFunc("GET /rabbitmq/permissions/{user}", mainHandler.serveRabbitMQPermissions)
+	mux.HandleFunc("GET /rabbitmq/consumer-groups", mainHandler.serveRabbitMQConsumerGroups)
+	mux.HandleFunc("POST /rabbitmq/queue/{name}/sync", mainHandler.debugOnly(mainHandler.serveRabbitMQMirrorSync))
+	mux.HandleFunc("GET /rabbitmq/queue/{name}/rate", mainHandler.serveRabbitMQMessageRate)
+	mux.HandleFunc("POST /rabbitmq/exchange/{name}/publish", mainHandler.serveRabbitMQPublishExchange)
+	mux.HandleFunc("DELETE /rabbitmq/exchange/{name}", mainHandler.debugOnly(mainHandler.serveRabbitMQDeleteExchange))
+	mux.HandleFunc("GET /rabbitmq/alarms", mainHandler.serveRabbitMQAlarms)
+	mux.HandleFunc("GET /rabbitmq/shovel/{name}", mainHandler.serveRabbitMQShovelStatus)
+	mux.HandleFunc("GET /rabbitmq/nodes", mainHandler.serveRabbitMQListNodes)
+	mux.HandleFunc("GET /rabbitmq/nodes/{name}", mainHandler.serveRabbitMQNodeInfo)
+	mux.HandleFunc("GET /rabbitmq/version", mainHandler.serveRabbitMQVersion)
+	mux.HandleFunc("POST /rabbitmq/reprocess", mainHandler.debugOnly(mainHandler.serveRabbitMQReprocess))
+	mux.HandleFunc("POST /rabbitmq/nack-all", mainHandler.debugOnly(mainHandler.serveRabbitMQNackAll))
+	mux.HandleFunc("POST /rabbitmq/clear-all", mainHandler.debugOnly(
+		mainHandler.requireConfirmToken(rabbitmqClearAllRejectedTotal, mainHandler.serveRabbitMQClearAll)))
 
 	// OIDC-specific: Add OIDC routes
 	mux.HandleFunc("/auth/{provider}/callback", mainHandler.serveAuthCallback)
@@ -575,6 +3202,10 @@ func main() {
 	})
 	mux.HandleFunc("/profile", mainHandler.serveProfile)
 
+	prometheus.MustRegister(otelExportDuration, otelDroppedSpansTotal, rabbitmqConnectionUp, rabbitmqChannelCount,
+		rabbitmqDeadLetterQueueDepth,
+		vacuumFullRejectedTotal, rabbitmqClearAllRejectedTotal, postgresSequencePercentUsed,
+		postgresTableStats, postgresWalLagBytes, postgresConnectionsByState, postgresPoolOpen, postgresPoolInUse, postgresPoolIdle, newPostgresPoolWaitTotal(svc))
 	if config.MetricsPort != config.Port {
 		prometheus.MustRegister(requestCounter)
 
@@ -596,7 +3227,7 @@ func main() {
 
 	server := &http.Server{
 		Addr:    ":" + config.Port,
-		Handler: mux,
+		Handler: mainHandler.OIDCMiddleware(mux),
 	}
 	go func() {
 		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
@@ -605,6 +3236,20 @@ func main() {
 		log.Println("Stopped serving new connections.")
 	}()
 
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	if rabbitmqURL != "" {
+		go reportRabbitMQMetrics(backgroundCtx, mainHandler.service, config.RabbitMQMetricsInterval, config.RabbitMQDLQAlertThreshold)
+	}
+	if postgresqlURL != "" {
+		go reportPostgresqlMetrics(backgroundCtx, mainHandler.service, config.PostgresqlMetricsInterval)
+		go reportPostgresqlPoolMetrics(backgroundCtx, mainHandler.service, config.DBMetricsInterval)
+		go checkPostgresqlSSL(backgroundCtx, mainHandler.service)
+	}
+	if postgresqlURL != "" && rabbitmqURL != "" {
+		go relayOutbox(backgroundCtx, mainHandler.service, config.OutboxPollInterval)
+	}
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
@@ -615,5 +3260,9 @@ func main() {
 	if err := server.Shutdown(shutdownCtx); err != nil {
 		log.Fatalf("HTTP shutdown error: %v", err)
 	}
+	stopBackground()
+	if err := mainHandler.service.Close(); err != nil {
+		log.Printf("Service close error: %v", err)
+	}
 	log.Println("Graceful shutdown complete.")
 }
@@ -0,0 +1,74 @@
+// Copyright 2025 Canonical Ltd.
+// See LICENSE file for licensing details.
+
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+// idToken returns an unsigned ID token with the given JSON claims.
+func idToken(claims string) string {
+	return "e30." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".sig"
+}
+
+func TestValidateIDToken(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	tests := []struct {
+		name    string
+		token   string
+		skew    time.Duration
+		wantErr bool
+	}{
+		{"valid", idToken(`{"exp": 1700000060, "iat": 1699999990}`), 0, false},
+		{"no exp", idToken(`{"iat": 1699999990}`), 0, true},
+		{"expired", idToken(`{"exp": 1699999999}`), 0, true},
+		{"expires now", idToken(`{"exp": 1700000000}`), 0, false},
+		{"expired within skew", idToken(`{"exp": 1699999995}`), 5 * time.Second, false},
+		{"expired past skew", idToken(`{"exp": 1699999994}`), 5 * time.Second, true},
+		{"expired within goth skew", idToken(`{"exp": 1699999990}`), time.Minute, false},
+		{"expired past goth skew", idToken(`{"exp": 1699999989}`), time.Minute, true},
+		{"iat in the future", idToken(`{"exp": 1700000060, "iat": 1700000001}`), 0, true},
+		{"iat within skew", idToken(`{"exp": 1700000060, "iat": 1700000030}`), 30 * time.Second, false},
+		{"iat past skew", idToken(`{"exp": 1700000060, "iat": 1700000031}`), 30 * time.Second, true},
+		{"nbf in the future", idToken(`{"exp": 1700000060, "nbf": 1700000001}`), 0, true},
+		{"nbf within skew", idToken(`{"exp": 1700000060, "nbf": 1700000030}`), 30 * time.Second, false},
+		{"two parts", "e30." + base64.RawURLEncoding.EncodeToString([]byte(`{"exp": 1700000060}`)), 0, true},
+		{"bad base64", "e30.!!!.sig", 0, true},
+		{"bad json", idToken(`{"exp":`), 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateIDToken(tt.token, now, tt.skew)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateIDToken() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSessionExpired(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	maxAge := time.Hour
+	tests := []struct {
+		name   string
+		authAt string
+		want   bool
+	}{
+		{"fresh", "1699999000", false},
+		{"exactly max age", "1699996400", false},
+		{"past max age", "1699996399", true},
+		{"authenticated in the future", "1700000100", false},
+		{"missing auth_at", "", true},
+		{"malformed auth_at", "yesterday", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sessionExpired(tt.authAt, now, maxAge); got != tt.want {
+				t.Errorf("sessionExpired(%q) = %v, want %v", tt.authAt, got, tt.want)
+			}
+		})
+	}
+}